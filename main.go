@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"os"
 
 	"github.com/cleopatrio/proxy/logger"
@@ -21,9 +22,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Usage: proxy [run] | proxy redrive [-file <dead-letter file>]
+	if len(os.Args) > 1 && os.Args[1] == "redrive" {
+		redrive(os.Args[2:])
+		return
+	}
+
 	c := make(chan bool, 1)
 
-	go func() { proxy.Listen(proxy.PxFile) }()
+	go func() {
+		proxy.Listen(proxy.PxFile)
+		c <- true
+	}()
 
 	logger.Logger.
 		WithFields(logrus.Fields{
@@ -41,3 +51,29 @@ func main() {
 
 	<-c
 }
+
+// redrive - Replays the requests of a dead-letter file again.
+func redrive(args []string) {
+	flags := flag.NewFlagSet("redrive", flag.ExitOnError)
+	file := flags.String("file", proxy.PxFile.ReplayConfig().DeadLetterFile, "dead-letter file to re-drive")
+	flags.Parse(args)
+
+	report, err := proxy.Redrive(proxy.PxFile, *file)
+
+	fields := logrus.Fields{
+		"file":      *file,
+		"delivered": report.Delivered,
+		"failed":    report.Failed,
+		"invalid":   report.Invalid,
+	}
+
+	if err != nil {
+		logger.Logger.WithFields(fields).Fatal("Unable to re-drive dead letters ", err)
+	}
+
+	logger.Logger.WithFields(fields).Info("Re-drove dead letters 📬")
+
+	if report.Failed > 0 || report.Invalid > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/cleopatrio/proxy/logger"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// AdminSettings - Admin API (reports and cache purges), served apart from proxied traffic.
+type AdminSettings struct {
+	// Address the admin API listens on (disabled if empty).
+	// 	- It must differ from the proxy's port, and is best kept private (e.g. `127.0.0.1:9090`).
+	Address string `yaml:"address" example:"127.0.0.1:9090"`
+
+	// Bearer token required by every admin route (none if empty).
+	Token string `yaml:"token"`
+}
+
+// AdminApp - Routes of the admin API.
+func (xy *Server) AdminApp(settings AdminSettings) *fiber.App {
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+
+	if settings.Token != "" {
+		app.Use(func(c *fiber.Ctx) error {
+			authorization := c.Get(fiber.HeaderAuthorization)
+			token := strings.TrimPrefix(authorization, "Bearer ")
+
+			if !strings.HasPrefix(authorization, "Bearer ") || subtle.ConstantTimeCompare([]byte(token), []byte(settings.Token)) != 1 {
+				return c.SendStatus(fiber.StatusUnauthorized)
+			}
+
+			return c.Next()
+		})
+	}
+
+	app.Get("/admin/upstreams", xy.upstreamsHandler)
+	app.Post("/admin/cache/purge", xy.cachePurgeHandler)
+	app.Get("/admin/coalescing", xy.coalescingHandler)
+	app.Get("/admin/replay", xy.replayHandler)
+	app.Get("/admin/replay/comparisons", xy.comparisonsHandler)
+
+	return app
+}
+
+// listenAdmin - Serves the admin API in the background (if enabled), returning its app.
+func (xy *Server) listenAdmin(settings AdminSettings) *fiber.App {
+	if settings.Address == "" {
+		return nil
+	}
+
+	app := xy.AdminApp(settings)
+
+	go func() {
+		logger.Logger.WithFields(logrus.Fields{"address": settings.Address}).Info("Serving the admin API 🛠")
+
+		if err := app.Listen(settings.Address); err != nil {
+			logger.Logger.WithFields(logrus.Fields{"address": settings.Address, "error": err}).Error("Admin API stopped")
+		}
+	}()
+
+	return app
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func Test_AdminApp(t *testing.T) {
+	xy := &Server{}
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		status        int
+	}{
+		{name: "no token", status: http.StatusOK},
+		{name: "missing token", token: "secret", status: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", authorization: "Bearer other", status: http.StatusUnauthorized},
+		{name: "bare token", token: "secret", authorization: "secret", status: http.StatusUnauthorized},
+		{name: "bearer token", token: "secret", authorization: "Bearer secret", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := xy.AdminApp(AdminSettings{Token: tt.token})
+
+			for _, route := range []string{"/admin/upstreams", "/admin/coalescing", "/admin/replay", "/admin/replay/comparisons"} {
+				request := newRequest(http.MethodGet, route)
+				if tt.authorization != "" {
+					request.Header.Set("Authorization", tt.authorization)
+				}
+
+				response, err := app.Test(request, -1)
+				if err != nil {
+					t.Fatalf(`unexpected error %v`, err)
+				}
+
+				if response.StatusCode != tt.status {
+					t.Errorf(`expected %s to respond %d but got %d`, route, tt.status, response.StatusCode)
+				}
+			}
+		})
+	}
+}
+
+func Test_listenAdmin_Disabled(t *testing.T) {
+	if app := (&Server{}).listenAdmin(AdminSettings{Token: "secret"}); app != nil {
+		t.Errorf(`expected the admin API not to be served without an address`)
+	}
+}
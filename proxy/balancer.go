@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// Load-balancing policies
+	RoundRobinPolicy         LoadBalancingPolicy = "round-robin"
+	WeightedRoundRobinPolicy LoadBalancingPolicy = "weighted-round-robin"
+	RandomPolicy             LoadBalancingPolicy = "random"
+	LeastConnectionsPolicy   LoadBalancingPolicy = "least-connections"
+	ConsistentHashPolicy     LoadBalancingPolicy = "consistent-hash"
+
+	// Consistent hashing sources
+	HeaderHashSource   HashSource = "header"
+	CookieHashSource   HashSource = "cookie"
+	ClientIPHashSource HashSource = "ip"
+)
+
+// LoadBalancingPolicy - Controls how a backend is chosen among a path's upstreams.
+type LoadBalancingPolicy string
+
+// HashSource - Request attribute used as the consistent hashing key.
+type HashSource string
+
+// LoadBalancerSettings - Load-balancing configuration of a path.
+type LoadBalancerSettings struct {
+	// Defaults to `round-robin`.
+	Policy LoadBalancingPolicy `yaml:"policy" example:"least-connections"`
+
+	// Only used by the `consistent-hash` policy [header/cookie/ip].
+	HashOn HashSource `yaml:"hashOn" example:"header"`
+
+	// Header or cookie name used by the `consistent-hash` policy.
+	HashKey string `yaml:"hashKey" example:"X-User-Id"`
+}
+
+// RequestKey - Extracts the consistent hashing key from the request.
+//
+// Client IPs are resolved through trusted proxies.
+func (lb LoadBalancerSettings) RequestKey(c *fiber.Ctx, forwarding *Forwarding) string {
+	if lb.Policy != ConsistentHashPolicy {
+		return ""
+	}
+
+	switch lb.HashOn {
+	case HeaderHashSource:
+		return c.Get(lb.HashKey)
+	case CookieHashSource:
+		return c.Cookies(lb.HashKey)
+	default:
+		return forwarding.ClientIP(c)
+	}
+}
+
+// Balancer - Chooses one of the candidate backends.
+//
+// `key` is only meaningful to hashing balancers and may be empty.
+type Balancer interface {
+	Pick(backends []*Backend, key string) *Backend
+}
+
+// NewBalancer - Creates the balancer for a policy (defaults to round-robin).
+func NewBalancer(policy LoadBalancingPolicy) Balancer {
+	switch policy {
+	case WeightedRoundRobinPolicy:
+		return &weightedRoundRobinBalancer{weights: map[*Backend]int{}}
+	case RandomPolicy:
+		return &randomBalancer{}
+	case LeastConnectionsPolicy:
+		return &leastConnectionsBalancer{}
+	case ConsistentHashPolicy:
+		return &consistentHashBalancer{}
+	default:
+		return &roundRobinBalancer{}
+	}
+}
+
+type roundRobinBalancer struct {
+	mu   sync.Mutex
+	next int
+}
+
+func (b *roundRobinBalancer) Pick(backends []*Backend, _ string) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	backend := backends[b.next%len(backends)]
+	b.next++
+
+	return backend
+}
+
+// weightedRoundRobinBalancer - Smooth weighted round-robin (as implemented by nginx).
+type weightedRoundRobinBalancer struct {
+	mu      sync.Mutex
+	weights map[*Backend]int
+}
+
+func (b *weightedRoundRobinBalancer) Pick(backends []*Backend, _ string) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var selected *Backend
+	total := 0
+
+	for _, backend := range backends {
+		weight := backend.Upstream.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+
+		total += weight
+		b.weights[backend] += weight
+
+		if selected == nil || b.weights[backend] > b.weights[selected] {
+			selected = backend
+		}
+	}
+
+	b.weights[selected] -= total
+
+	return selected
+}
+
+type randomBalancer struct{}
+
+func (b *randomBalancer) Pick(backends []*Backend, _ string) *Backend {
+	if len(backends) == 0 {
+		return nil
+	}
+
+	return backends[rand.Intn(len(backends))]
+}
+
+type leastConnectionsBalancer struct{}
+
+func (b *leastConnectionsBalancer) Pick(backends []*Backend, _ string) *Backend {
+	var selected *Backend
+
+	for _, backend := range backends {
+		if selected == nil || backend.ActiveConnections() < selected.ActiveConnections() {
+			selected = backend
+		}
+	}
+
+	return selected
+}
+
+// consistentHashBalancer - Rendezvous (highest random weight) hashing.
+//
+// Only the keys owned by a backend move when that backend leaves the candidate list.
+type consistentHashBalancer struct{ fallback randomBalancer }
+
+func (b *consistentHashBalancer) Pick(backends []*Backend, key string) *Backend {
+	if key == "" {
+		return b.fallback.Pick(backends, key)
+	}
+
+	var selected *Backend
+	var highest uint64
+
+	for _, backend := range backends {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte(backend.Upstream.Address()))
+
+		if score := h.Sum64(); selected == nil || score > highest {
+			selected, highest = backend, score
+		}
+	}
+
+	return selected
+}
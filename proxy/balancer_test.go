@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// newTestUpstream - Starts an HTTP server that answers every request with `name`.
+func newTestUpstream(t *testing.T, name string, handler http.HandlerFunc) ProxyUpstream {
+	t.Helper()
+
+	if handler == nil {
+		handler = func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, name) }
+	}
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+
+	return ProxyUpstream{Host: host, Port: portNumber}
+}
+
+// newTestServer - Registers a single prefix path for `example.com`.
+func newTestServer(t *testing.T, path ProxyPath) *Server {
+	t.Helper()
+
+	if path.Path == "" {
+		path.Path = "/"
+	}
+
+	if path.PathType == "" {
+		path.PathType = PrefixPathType
+	}
+
+	xy := &Server{}
+	xy.registerRule(ProxyEndpointRule{Host: "example.com", Paths: []ProxyPath{path}})
+
+	return xy
+}
+
+// send - Sends a request to `example.com` and returns the response status and body.
+func send(t *testing.T, xy *Server, request *http.Request) (int, string) {
+	t.Helper()
+
+	response, err := xy.Hosts["example.com"].Fiber.Test(request, -1)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+
+	return response.StatusCode, string(body)
+}
+
+func newRequest(method, target string) *http.Request {
+	return httptest.NewRequest(method, "http://example.com"+target, nil)
+}
+
+func Test_RoundRobinBalancer(t *testing.T) {
+	xy := newTestServer(t, ProxyPath{
+		Upstreams: []ProxyUpstream{
+			newTestUpstream(t, "a", nil),
+			newTestUpstream(t, "b", nil),
+			newTestUpstream(t, "c", nil),
+		},
+	})
+
+	expected := []string{"a", "b", "c", "a", "b", "c"}
+	for _, name := range expected {
+		if _, body := send(t, xy, newRequest(http.MethodGet, "/")); body != name {
+			t.Errorf(`expected %s but got %s`, name, body)
+		}
+	}
+}
+
+func Test_WeightedRoundRobinBalancer(t *testing.T) {
+	a, b := newTestUpstream(t, "a", nil), newTestUpstream(t, "b", nil)
+	a.Weight = 3
+
+	xy := newTestServer(t, ProxyPath{
+		Upstreams:    []ProxyUpstream{a, b},
+		LoadBalancer: LoadBalancerSettings{Policy: WeightedRoundRobinPolicy},
+	})
+
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		_, body := send(t, xy, newRequest(http.MethodGet, "/"))
+		counts[body]++
+	}
+
+	if counts["a"] != 6 || counts["b"] != 2 {
+		t.Errorf(`expected a=6 and b=2 but got %v`, counts)
+	}
+}
+
+func Test_RandomBalancer(t *testing.T) {
+	xy := newTestServer(t, ProxyPath{
+		Upstreams: []ProxyUpstream{
+			newTestUpstream(t, "a", nil),
+			newTestUpstream(t, "b", nil),
+		},
+		LoadBalancer: LoadBalancerSettings{Policy: RandomPolicy},
+	})
+
+	counts := map[string]int{}
+	for i := 0; i < 50; i++ {
+		_, body := send(t, xy, newRequest(http.MethodGet, "/"))
+		counts[body]++
+	}
+
+	if counts["a"] == 0 || counts["b"] == 0 {
+		t.Errorf(`expected both backends to receive traffic but got %v`, counts)
+	}
+}
+
+func Test_LeastConnectionsBalancer(t *testing.T) {
+	received, unblock := make(chan bool), make(chan bool)
+
+	slow := newTestUpstream(t, "slow", func(w http.ResponseWriter, r *http.Request) {
+		received <- true
+		<-unblock
+		io.WriteString(w, "slow")
+	})
+
+	xy := newTestServer(t, ProxyPath{
+		Upstreams:    []ProxyUpstream{slow, newTestUpstream(t, "fast", nil)},
+		LoadBalancer: LoadBalancerSettings{Policy: LeastConnectionsPolicy},
+	})
+
+	done := make(chan string)
+	go func() {
+		_, body := send(t, xy, newRequest(http.MethodGet, "/"))
+		done <- body
+	}()
+
+	<-received
+
+	for i := 0; i < 3; i++ {
+		if _, body := send(t, xy, newRequest(http.MethodGet, "/")); body != "fast" {
+			t.Errorf(`expected fast but got %s`, body)
+		}
+	}
+
+	close(unblock)
+
+	if body := <-done; body != "slow" {
+		t.Errorf(`expected slow but got %s`, body)
+	}
+}
+
+func Test_ConsistentHashBalancer(t *testing.T) {
+	upstreams := []ProxyUpstream{
+		newTestUpstream(t, "a", nil),
+		newTestUpstream(t, "b", nil),
+		newTestUpstream(t, "c", nil),
+	}
+
+	tests := []struct {
+		name     string
+		settings LoadBalancerSettings
+		prepare  func(r *http.Request, key string)
+	}{
+		{
+			name:     "header",
+			settings: LoadBalancerSettings{Policy: ConsistentHashPolicy, HashOn: HeaderHashSource, HashKey: "X-User-Id"},
+			prepare:  func(r *http.Request, key string) { r.Header.Set("X-User-Id", key) },
+		},
+		{
+			name:     "cookie",
+			settings: LoadBalancerSettings{Policy: ConsistentHashPolicy, HashOn: CookieHashSource, HashKey: "session"},
+			prepare:  func(r *http.Request, key string) { r.AddCookie(&http.Cookie{Name: "session", Value: key}) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xy := newTestServer(t, ProxyPath{Upstreams: upstreams, LoadBalancer: tt.settings})
+
+			backends := map[string]bool{}
+			for _, key := range []string{"alice", "bob", "carol", "dave", "erin", "frank"} {
+				var first string
+
+				for i := 0; i < 5; i++ {
+					request := newRequest(http.MethodGet, "/")
+					tt.prepare(request, key)
+
+					_, body := send(t, xy, request)
+					if i == 0 {
+						first = body
+					} else if body != first {
+						t.Errorf(`expected key %s to stick to %s but got %s`, key, first, body)
+					}
+				}
+
+				backends[first] = true
+			}
+
+			if len(backends) < 2 {
+				t.Errorf(`expected keys to spread across backends but got %v`, backends)
+			}
+		})
+	}
+}
+
+func Test_ConsistentHashBalancer_Rebalance(t *testing.T) {
+	backends := []*Backend{
+		{Upstream: ProxyUpstream{Host: "10.0.1.1", Port: 80}},
+		{Upstream: ProxyUpstream{Host: "10.0.1.2", Port: 80}},
+		{Upstream: ProxyUpstream{Host: "10.0.1.3", Port: 80}},
+	}
+
+	balancer := NewBalancer(ConsistentHashPolicy)
+
+	owners := map[string]*Backend{}
+	for i := 0; i < 100; i++ {
+		ip := "192.168.0." + strconv.Itoa(i)
+		owners[ip] = balancer.Pick(backends, ip)
+	}
+
+	// Removing a backend must only move the keys it owned.
+	remaining := backends[:2]
+	for ip, owner := range owners {
+		if owner == backends[2] {
+			continue
+		}
+
+		if backend := balancer.Pick(remaining, ip); backend != owner {
+			t.Errorf(`expected %s to stay on %s but moved to %s`, ip, owner.Upstream.Host, backend.Upstream.Host)
+		}
+	}
+}
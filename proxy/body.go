@@ -3,19 +3,59 @@ package proxy
 import (
 	"bytes"
 	"io"
+	"net/http"
 )
 
+// RequestBody - Buffered request payload that can be read again from the start.
 type RequestBody struct {
-	Data  []byte
-	state bytes.Buffer
+	Data   []byte
+	reader *bytes.Reader
 }
 
-func (rb *RequestBody) Close() error {
-	return io.NopCloser(&rb.state).Close()
+// NewRequestBody - Wraps `data` (which must not be modified while the body is in use).
+func NewRequestBody(data []byte) *RequestBody {
+	return &RequestBody{Data: data}
 }
 
+// Attach - Sends the body with a request, letting the client replay it (e.g. on redirects).
+func (rb *RequestBody) Attach(request *http.Request) {
+	if len(rb.Data) == 0 {
+		request.Body, request.GetBody, request.ContentLength = http.NoBody, nil, 0
+		return
+	}
+
+	request.Body = rb
+	request.GetBody = rb.GetBody
+	request.ContentLength = rb.Len()
+}
+
+// GetBody - New reader of the whole payload.
+func (rb *RequestBody) GetBody() (io.ReadCloser, error) {
+	return NewRequestBody(rb.Data), nil
+}
+
+// Len - Size of the whole payload.
+func (rb *RequestBody) Len() int64 { return int64(len(rb.Data)) }
+
+// Rewind - Reads the payload again from the start.
+func (rb *RequestBody) Rewind() { rb.state().Reset(rb.Data) }
+
 func (rb *RequestBody) Read(buffer []byte) (int, error) {
-	return rb.state.Read(buffer)
+	return rb.state().Read(buffer)
+}
+
+func (rb *RequestBody) Seek(offset int64, whence int) (int64, error) {
+	return rb.state().Seek(offset, whence)
+}
+
+func (rb *RequestBody) Close() error { return nil }
+
+func (rb *RequestBody) state() *bytes.Reader {
+	if rb.reader == nil {
+		rb.reader = bytes.NewReader(rb.Data)
+	}
+
+	return rb.reader
 }
 
-var _ io.ReadCloser = (*RequestBody)(nil)
+var _ io.ReadSeekCloser = (*RequestBody)(nil)
@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// payload - Binary body (with zero bytes and invalid UTF-8) larger than a read buffer.
+var payload = append(bytes.Repeat([]byte("payload\x00\xff"), 1024), '\n')
+
+func Test_RequestBody(t *testing.T) {
+	body := NewRequestBody(payload)
+
+	for i := 0; i < 2; i++ {
+		data, err := io.ReadAll(body)
+		if err != nil || !bytes.Equal(data, payload) {
+			t.Fatalf(`expected the whole payload (read %d) but got %d bytes (%v)`, i+1, len(data), err)
+		}
+
+		body.Rewind()
+	}
+
+	body.Seek(int64(len(payload)-1), io.SeekStart)
+	if data, _ := io.ReadAll(body); string(data) != "\n" {
+		t.Errorf(`expected to read from the offset but got %q`, data)
+	}
+
+	// GetBody readers are independent of the drained body.
+	copied, _ := body.GetBody()
+	if data, _ := io.ReadAll(copied); !bytes.Equal(data, payload) {
+		t.Errorf(`expected GetBody to return the whole payload but got %d bytes`, len(data))
+	}
+
+	request := &http.Request{}
+	body.Attach(request)
+
+	if request.ContentLength != int64(len(payload)) || request.Body != body || request.GetBody == nil {
+		t.Errorf(`unexpected request %+v`, request)
+	}
+
+	NewRequestBody(nil).Attach(request)
+
+	if request.ContentLength != 0 || request.Body != http.NoBody || request.GetBody != nil {
+		t.Errorf(`expected an empty body but got %+v`, request)
+	}
+}
+
+// bodyRecorder - Upstream handler recording the body and length of every request.
+type bodyRecorder struct {
+	mu      sync.Mutex
+	bodies  [][]byte
+	lengths []int64
+}
+
+// record - Records a request, returning how many were received so far.
+func (br *bodyRecorder) record(r *http.Request) int {
+	body, _ := io.ReadAll(r.Body)
+
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	br.bodies = append(br.bodies, body)
+	br.lengths = append(br.lengths, r.ContentLength)
+
+	return len(br.bodies)
+}
+
+func (br *bodyRecorder) expect(t *testing.T, requests int) {
+	t.Helper()
+
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	if len(br.bodies) != requests {
+		t.Fatalf(`expected %d requests but got %d`, requests, len(br.bodies))
+	}
+
+	for i, body := range br.bodies {
+		if !bytes.Equal(body, payload) || br.lengths[i] != int64(len(payload)) {
+			t.Errorf(`expected request %d to carry the payload but got %d bytes (Content-Length %d)`, i+1, len(body), br.lengths[i])
+		}
+	}
+}
+
+func Test_MakeHTTPRequest_Body(t *testing.T) {
+	recorder := &bodyRecorder{}
+
+	upstream := newTestUpstream(t, "", func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt fails and is retried.
+		if recorder.record(r) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	xy := newTestServer(t, ProxyPath{Upstream: &upstream, Retry: &RetryPolicy{Attempts: 2, Backoff: time.Millisecond}})
+
+	request := httptest.NewRequest(http.MethodPut, "http://example.com/upload", bytes.NewReader(payload))
+	if status, _ := send(t, xy, request); status != http.StatusOK {
+		t.Errorf(`expected %d but got %d`, http.StatusOK, status)
+	}
+
+	recorder.expect(t, 2)
+}
+
+func Test_RequestBody_Redirect(t *testing.T) {
+	recorder := &bodyRecorder{}
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.record(r)
+
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusTemporaryRedirect)
+		}
+	}))
+	t.Cleanup(target.Close)
+
+	request, _ := http.NewRequest(http.MethodPost, target.URL+"/old", nil)
+	NewRequestBody(payload).Attach(request)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	response.Body.Close()
+
+	if response.Request.URL.Path != "/new" {
+		t.Errorf(`expected the redirect to be followed but ended at %s`, response.Request.URL)
+	}
+
+	recorder.expect(t, 2)
+}
+
+func Test_MakeHTTPRequest_EncodedBody(t *testing.T) {
+	compressed := &bytes.Buffer{}
+	writer := gzip.NewWriter(compressed)
+	writer.Write(payload)
+	writer.Close()
+
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{name: "gzip", body: compressed.Bytes()},
+		{name: "malformed gzip", body: compressed.Bytes()[:compressed.Len()/2]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received []byte
+			var encoding string
+
+			upstream := newTestUpstream(t, "", func(w http.ResponseWriter, r *http.Request) {
+				received, _ = io.ReadAll(r.Body)
+				encoding = r.Header.Get("Content-Encoding")
+			})
+
+			xy := newTestServer(t, ProxyPath{Upstream: &upstream})
+
+			request := httptest.NewRequest(http.MethodPost, "http://example.com/upload", bytes.NewReader(tt.body))
+			request.Header.Set("Content-Encoding", "gzip")
+			send(t, xy, request)
+
+			if !bytes.Equal(received, tt.body) || encoding != "gzip" {
+				t.Errorf(`expected the encoded body to be forwarded as is but got %d bytes (Content-Encoding %q)`, len(received), encoding)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cleopatrio/proxy/logger"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Circuit breaker states
+	ClosedCircuitState   CircuitState = "closed"
+	OpenCircuitState     CircuitState = "open"
+	HalfOpenCircuitState CircuitState = "half-open"
+
+	// Circuit breaker defaults
+	DefaultCircuitOpenDuration    time.Duration = 5 * time.Second
+	DefaultCircuitMaxOpenDuration time.Duration = 2 * time.Minute
+	DefaultCircuitHalfOpenProbes  int           = 1
+	DefaultCircuitWindowSize      int           = 20
+)
+
+var ErrCircuitOpen = errors.New(`upstream circuit is open`)
+
+// CircuitState - State of an upstream circuit breaker.
+type CircuitState string
+
+// CircuitBreakerSettings - Controls when an upstream is ejected after failing requests.
+//
+// Requests failing with a connection error or a 5xx status count as failures.
+type CircuitBreakerSettings struct {
+	// Trips the circuit after this many consecutive failures (0 disables the check).
+	ConsecutiveFailures int `yaml:"consecutiveFailures" example:"5"`
+
+	// Trips the circuit once this percentage of the window failed (0 disables the check).
+	ErrorRate float64 `yaml:"errorRate" example:"50"`
+
+	// Number of most recent requests considered by `errorRate`.
+	WindowSize int `yaml:"windowSize" example:"20"`
+
+	// Minimum number of requests in the window before `errorRate` is evaluated.
+	MinimumRequests int `yaml:"minimumRequests" example:"10"`
+
+	// The upstream is ejected for this long the first time the circuit opens.
+	OpenDuration time.Duration `yaml:"openDuration" example:"5s"`
+
+	// The ejection period doubles every time a half-open probe fails, up to this limit.
+	MaxOpenDuration time.Duration `yaml:"maxOpenDuration" example:"2m"`
+
+	// Number of trial requests let through while half-open.
+	HalfOpenRequests int `yaml:"halfOpenRequests" example:"1"`
+}
+
+func (cs CircuitBreakerSettings) withDefaults() CircuitBreakerSettings {
+	if cs.OpenDuration <= 0 {
+		cs.OpenDuration = DefaultCircuitOpenDuration
+	}
+
+	if cs.MaxOpenDuration <= 0 {
+		cs.MaxOpenDuration = DefaultCircuitMaxOpenDuration
+	}
+
+	if cs.MaxOpenDuration < cs.OpenDuration {
+		cs.MaxOpenDuration = cs.OpenDuration
+	}
+
+	if cs.HalfOpenRequests <= 0 {
+		cs.HalfOpenRequests = DefaultCircuitHalfOpenProbes
+	}
+
+	if cs.WindowSize <= 0 {
+		cs.WindowSize = DefaultCircuitWindowSize
+	}
+
+	return cs
+}
+
+// CircuitBreaker - Passive outlier detection for a single upstream.
+//
+// A nil circuit breaker never trips.
+type CircuitBreaker struct {
+	Settings CircuitBreakerSettings
+	Upstream string
+
+	mu       sync.Mutex
+	now      func() time.Time
+	state    CircuitState
+	openedAt time.Time
+	backoff  time.Duration
+	failures int
+	window   []bool
+	next     int
+	probes   int
+}
+
+// NewCircuitBreaker - Creates a closed circuit breaker.
+func NewCircuitBreaker(settings CircuitBreakerSettings, upstream string) *CircuitBreaker {
+	settings = settings.withDefaults()
+
+	return &CircuitBreaker{
+		Settings: settings,
+		Upstream: upstream,
+		now:      time.Now,
+		state:    ClosedCircuitState,
+		backoff:  settings.OpenDuration,
+	}
+}
+
+// State - Current state of the circuit.
+func (cb *CircuitBreaker) State() CircuitState {
+	if cb == nil {
+		return ClosedCircuitState
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.advance()
+
+	return cb.state
+}
+
+// Ready - Checks if a request could be sent without reserving a half-open probe.
+func (cb *CircuitBreaker) Ready() bool {
+	if cb == nil {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.advance()
+
+	switch cb.state {
+	case OpenCircuitState:
+		return false
+	case HalfOpenCircuitState:
+		return cb.probes < cb.Settings.HalfOpenRequests
+	default:
+		return true
+	}
+}
+
+// Allow - Reserves the right to send a request to the upstream.
+func (cb *CircuitBreaker) Allow() bool {
+	if cb == nil {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.advance()
+
+	switch cb.state {
+	case OpenCircuitState:
+		return false
+	case HalfOpenCircuitState:
+		if cb.probes >= cb.Settings.HalfOpenRequests {
+			return false
+		}
+
+		cb.probes++
+		return true
+	default:
+		return true
+	}
+}
+
+// RetryAfter - Time left until the circuit lets requests through again.
+func (cb *CircuitBreaker) RetryAfter() time.Duration {
+	if cb == nil {
+		return 0
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state != OpenCircuitState {
+		return 0
+	}
+
+	return cb.openedAt.Add(cb.backoff).Sub(cb.now())
+}
+
+// Cancel - Releases a request allowed by the circuit without reporting an outcome.
+func (cb *CircuitBreaker) Cancel() {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == HalfOpenCircuitState && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+// Record - Reports the outcome of a request allowed by the circuit.
+func (cb *CircuitBreaker) Record(success bool) {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case HalfOpenCircuitState:
+		if success {
+			cb.close()
+		} else {
+			cb.backoff *= 2
+			if cb.backoff > cb.Settings.MaxOpenDuration {
+				cb.backoff = cb.Settings.MaxOpenDuration
+			}
+
+			cb.open()
+		}
+	case ClosedCircuitState:
+		cb.observe(success)
+
+		if cb.tripped() {
+			cb.open()
+		}
+	}
+}
+
+// observe - Adds an outcome to the consecutive failure count and the rolling window.
+func (cb *CircuitBreaker) observe(success bool) {
+	if success {
+		cb.failures = 0
+	} else {
+		cb.failures++
+	}
+
+	if len(cb.window) < cb.Settings.WindowSize {
+		cb.window = append(cb.window, success)
+		return
+	}
+
+	cb.window[cb.next] = success
+	cb.next = (cb.next + 1) % cb.Settings.WindowSize
+}
+
+func (cb *CircuitBreaker) tripped() bool {
+	if cb.Settings.ConsecutiveFailures > 0 && cb.failures >= cb.Settings.ConsecutiveFailures {
+		return true
+	}
+
+	if cb.Settings.ErrorRate <= 0 || len(cb.window) == 0 || len(cb.window) < cb.Settings.MinimumRequests {
+		return false
+	}
+
+	failed := 0
+	for _, success := range cb.window {
+		if !success {
+			failed++
+		}
+	}
+
+	return float64(failed)*100/float64(len(cb.window)) >= cb.Settings.ErrorRate
+}
+
+// advance - Moves an open circuit to half-open once its backoff elapsed.
+func (cb *CircuitBreaker) advance() {
+	if cb.state == OpenCircuitState && !cb.now().Before(cb.openedAt.Add(cb.backoff)) {
+		cb.state = HalfOpenCircuitState
+		cb.probes = 0
+	}
+}
+
+func (cb *CircuitBreaker) open() {
+	cb.state = OpenCircuitState
+	cb.openedAt = cb.now()
+
+	logger.Logger.WithFields(logrus.Fields{
+		"upstream":      cb.Upstream,
+		"circuit.state": cb.state,
+		"backoff":       cb.backoff.String(),
+		"failures":      cb.failures,
+	}).Warn("Upstream circuit opened ⚡️")
+}
+
+func (cb *CircuitBreaker) close() {
+	cb.state = ClosedCircuitState
+	cb.backoff = cb.Settings.OpenDuration
+	cb.failures = 0
+	cb.window = cb.window[:0]
+	cb.next = 0
+
+	logger.Logger.WithFields(logrus.Fields{
+		"upstream":      cb.Upstream,
+		"circuit.state": cb.state,
+	}).Info("Upstream circuit closed 💚")
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu      sync.Mutex
+	current time.Time
+}
+
+func (fc *fakeClock) now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return fc.current
+}
+
+func (fc *fakeClock) advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.current = fc.current.Add(d)
+}
+
+func newTestCircuitBreaker(settings CircuitBreakerSettings) (*CircuitBreaker, *fakeClock) {
+	clock := &fakeClock{current: time.Unix(0, 0)}
+
+	cb := NewCircuitBreaker(settings, "test")
+	cb.now = clock.now
+
+	return cb, clock
+}
+
+func Test_CircuitBreaker_ConsecutiveFailures(t *testing.T) {
+	cb, clock := newTestCircuitBreaker(CircuitBreakerSettings{
+		ConsecutiveFailures: 3,
+		OpenDuration:        time.Second,
+		MaxOpenDuration:     3 * time.Second,
+	})
+
+	cb.Record(false)
+	cb.Record(false)
+	cb.Record(true)
+	cb.Record(false)
+	cb.Record(false)
+
+	if state := cb.State(); state != ClosedCircuitState {
+		t.Fatalf(`expected %s but got %s`, ClosedCircuitState, state)
+	}
+
+	cb.Record(false)
+
+	if state := cb.State(); state != OpenCircuitState || cb.Allow() {
+		t.Fatalf(`expected %s but got %s`, OpenCircuitState, state)
+	}
+
+	clock.advance(time.Second)
+
+	if state := cb.State(); state != HalfOpenCircuitState {
+		t.Fatalf(`expected %s but got %s`, HalfOpenCircuitState, state)
+	}
+
+	if !cb.Allow() || cb.Allow() {
+		t.Fatalf(`expected a single half-open probe`)
+	}
+
+	// A failed probe doubles the ejection period.
+	cb.Record(false)
+
+	if retryAfter := cb.RetryAfter(); retryAfter != 2*time.Second {
+		t.Fatalf(`expected backoff of 2s but got %s`, retryAfter)
+	}
+
+	clock.advance(2 * time.Second)
+	cb.Allow()
+	cb.Record(false)
+
+	if retryAfter := cb.RetryAfter(); retryAfter != 3*time.Second {
+		t.Fatalf(`expected backoff to be capped at 3s but got %s`, retryAfter)
+	}
+
+	clock.advance(3 * time.Second)
+	cb.Allow()
+	cb.Record(true)
+
+	if state := cb.State(); state != ClosedCircuitState {
+		t.Fatalf(`expected %s but got %s`, ClosedCircuitState, state)
+	}
+}
+
+func Test_CircuitBreaker_ErrorRate(t *testing.T) {
+	cb, _ := newTestCircuitBreaker(CircuitBreakerSettings{
+		ErrorRate:       50,
+		WindowSize:      4,
+		MinimumRequests: 4,
+	})
+
+	for _, success := range []bool{false, true, false} {
+		cb.Record(success)
+	}
+
+	if state := cb.State(); state != ClosedCircuitState {
+		t.Fatalf(`expected %s before reaching the minimum requests but got %s`, ClosedCircuitState, state)
+	}
+
+	cb.Record(true)
+
+	if state := cb.State(); state != OpenCircuitState {
+		t.Fatalf(`expected %s but got %s`, OpenCircuitState, state)
+	}
+}
+
+func Test_CircuitBreaker_FailFast(t *testing.T) {
+	var calls int32
+
+	failing := newTestUpstream(t, "failing", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	xy := newTestServer(t, ProxyPath{
+		Upstreams: []ProxyUpstream{failing},
+		CircuitBreaker: &CircuitBreakerSettings{
+			ConsecutiveFailures: 2,
+			OpenDuration:        time.Minute,
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		if status, _ := send(t, xy, newRequest(http.MethodGet, "/")); status != http.StatusInternalServerError {
+			t.Fatalf(`expected %d but got %d`, http.StatusInternalServerError, status)
+		}
+	}
+
+	response, err := xy.Hosts["example.com"].Fiber.Test(newRequest(http.MethodGet, "/"), -1)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf(`expected %d but got %d`, http.StatusServiceUnavailable, response.StatusCode)
+	}
+
+	if response.Header.Get("Retry-After") != "60" {
+		t.Errorf(`expected Retry-After of 60 but got %q`, response.Header.Get("Retry-After"))
+	}
+
+	if calls != 2 {
+		t.Errorf(`expected the upstream to be called twice but got %d`, calls)
+	}
+}
@@ -0,0 +1,917 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cleopatrio/proxy/helpers"
+	"github.com/cleopatrio/proxy/logger"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// Cache statuses (sent back in the `X-Cache` header)
+	HitCacheStatus   CacheStatus = "HIT"
+	MissCacheStatus  CacheStatus = "MISS"
+	StaleCacheStatus CacheStatus = "STALE"
+
+	XCacheHeader = "X-Cache"
+
+	// Cache stores
+	MemoryCacheStoreType CacheStoreType = "memory"
+	DiskCacheStoreType   CacheStoreType = "disk"
+
+	// Cache defaults
+	DefaultCacheMaxSize            int64  = 64 << 20
+	DefaultCacheMaxObjectSize      int64  = 1 << 20
+	DefaultDiskCacheMaxSize        int64  = 1 << 30
+	DefaultDiskCacheMaxObjectSize  int64  = 64 << 20
+	DefaultCacheSurrogateKeyHeader string = "Surrogate-Key"
+
+	// Heuristic freshness is a fraction of the time since the last modification (RFC 9111, section 4.2.2).
+	heuristicFreshnessFraction = 10
+	maxHeuristicFreshness      = 24 * time.Hour
+)
+
+var (
+	// Statuses that may be stored without explicit freshness (RFC 9110, section 15.1).
+	// Partial content is not cached since range requests bypass the cache.
+	heuristicallyCacheableStatuses = []int{
+		http.StatusOK,
+		http.StatusNonAuthoritativeInfo,
+		http.StatusNoContent,
+		http.StatusMultipleChoices,
+		http.StatusMovedPermanently,
+		http.StatusPermanentRedirect,
+		http.StatusNotFound,
+		http.StatusMethodNotAllowed,
+		http.StatusGone,
+		http.StatusRequestURITooLong,
+		http.StatusNotImplemented,
+	}
+
+	// Request headers replaced by the cache's own validators when revalidating.
+	conditionalHeaders = []string{
+		fiber.HeaderIfMatch,
+		fiber.HeaderIfNoneMatch,
+		fiber.HeaderIfModifiedSince,
+		fiber.HeaderIfUnmodifiedSince,
+		fiber.HeaderIfRange,
+	}
+
+	// Methods that do not invalidate stored responses (RFC 9110, section 9.2.1).
+	safeMethods = []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodOptions,
+		http.MethodTrace,
+	}
+)
+
+// CacheStatus - How a response was served by the cache.
+type CacheStatus string
+
+// CacheStoreType - Where stored responses are kept.
+type CacheStoreType string
+
+// CacheSettings - Shared HTTP cache (RFC 9111) of a path.
+type CacheSettings struct {
+	// Upper bound of the total size of stored responses (in bytes).
+	// 	- Defaults to 64MB in memory and 1GB on disk.
+	MaxSize int64 `yaml:"maxSize" example:"67108864"`
+
+	// Larger responses are not stored (in bytes).
+	// 	- Defaults to 1MB in memory and 64MB on disk, where bodies are streamed instead of buffered.
+	MaxObjectSize int64 `yaml:"maxObjectSize" example:"1048576"`
+
+	// Where responses are kept [memory/disk] (defaults to memory).
+	Store CacheStoreType `yaml:"store" example:"disk"`
+
+	// Root directory of disk stores (each path gets its own sub-directory).
+	Directory string `yaml:"directory" example:"/var/cache/proxy"`
+
+	// Response header listing the surrogate keys (tags) used to purge responses.
+	SurrogateKeyHeader string `yaml:"surrogateKeyHeader" example:"Surrogate-Key"`
+}
+
+func (cs CacheSettings) withDefaults() CacheSettings {
+	if cs.Store == "" {
+		cs.Store = MemoryCacheStoreType
+	}
+
+	if cs.Directory == "" {
+		cs.Directory = filepath.Join(os.TempDir(), "proxy-cache")
+	}
+
+	if cs.SurrogateKeyHeader == "" {
+		cs.SurrogateKeyHeader = DefaultCacheSurrogateKeyHeader
+	}
+
+	if cs.MaxSize <= 0 {
+		cs.MaxSize = DefaultCacheMaxSize
+		if cs.Store == DiskCacheStoreType {
+			cs.MaxSize = DefaultDiskCacheMaxSize
+		}
+	}
+
+	if cs.MaxObjectSize <= 0 {
+		cs.MaxObjectSize = DefaultCacheMaxObjectSize
+		if cs.Store == DiskCacheStoreType {
+			cs.MaxObjectSize = DefaultDiskCacheMaxObjectSize
+		}
+	}
+
+	if cs.MaxObjectSize > cs.MaxSize {
+		cs.MaxObjectSize = cs.MaxSize
+	}
+
+	return cs
+}
+
+// cacheControl - Parsed `Cache-Control` directives (names are lowercase).
+type cacheControl map[string]string
+
+func parseCacheControl(headers http.Header) cacheControl {
+	cc := cacheControl{}
+
+	for _, value := range headers.Values(fiber.HeaderCacheControl) {
+		for _, directive := range strings.Split(value, ",") {
+			name, argument, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				cc[name] = strings.Trim(strings.TrimSpace(argument), `"`)
+			}
+		}
+	}
+
+	return cc
+}
+
+// requestCacheControl - Directives of the incoming request (`Pragma: no-cache` is honored without `Cache-Control`).
+func requestCacheControl(c *fiber.Ctx) cacheControl {
+	headers := RequestHeaders(c)
+	cc := parseCacheControl(headers)
+
+	if headers.Get(fiber.HeaderCacheControl) == "" && strings.Contains(strings.ToLower(headers.Get(fiber.HeaderPragma)), "no-cache") {
+		cc["no-cache"] = ""
+	}
+
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds - Delta-seconds argument of a directive.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	value, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+// maxStale - Staleness accepted by the client (any staleness if `max-stale` has no argument).
+func (cc cacheControl) maxStale() (time.Duration, bool) {
+	if value, ok := cc["max-stale"]; ok && value == "" {
+		return time.Duration(math.MaxInt64), true
+	}
+
+	return cc.seconds("max-stale")
+}
+
+// CacheEntry - Stored response.
+//
+// Entries without a status mark responses that vary on request headers;
+// their variants are stored under keys derived from those headers.
+type CacheEntry struct {
+	Key string `json:"key"`
+
+	// Host and URI of the request.
+	URL string `json:"url"`
+
+	// Request headers that select this response.
+	Vary []string `json:"vary,omitempty"`
+
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"-"`
+
+	// Body of entries read from a disk store (instead of `Body`).
+	file *diskCacheBody
+
+	// When the request that fetched this response was sent, and when the response was received.
+	RequestTime  time.Time `json:"requestTime"`
+	ResponseTime time.Time `json:"responseTime"`
+}
+
+func (e *CacheEntry) marker() bool { return e.StatusCode == 0 }
+
+func (e *CacheEntry) bodySize() int64 {
+	if e.file != nil {
+		return e.file.size
+	}
+
+	return int64(len(e.Body))
+}
+
+// openBody - Reader of the body (to be closed).
+func (e *CacheEntry) openBody() io.ReadCloser {
+	if e.file != nil {
+		return e.file.open()
+	}
+
+	return io.NopCloser(bytes.NewReader(e.Body))
+}
+
+// retain - Keeps the body of an entry read from a disk store open until released once more.
+func (e *CacheEntry) retain() {
+	if e != nil && e.file != nil {
+		e.file.retain()
+	}
+}
+
+// release - Closes the file of an entry read from a disk store once its bodies are read.
+func (e *CacheEntry) release() {
+	if e != nil && e.file != nil {
+		e.file.release()
+	}
+}
+
+// Size - Approximate memory held by the entry.
+func (e *CacheEntry) Size() int64 {
+	size := len(e.Key) + len(e.URL) + len(e.Body)
+
+	for name, values := range e.Header {
+		for _, value := range values {
+			size += len(name) + len(value)
+		}
+	}
+
+	return int64(size)
+}
+
+func (e *CacheEntry) date() time.Time {
+	if date, err := http.ParseTime(e.Header.Get(fiber.HeaderDate)); err == nil {
+		return date
+	}
+
+	return e.ResponseTime
+}
+
+// FreshnessLifetime - How long the response is fresh for a shared cache (RFC 9111, section 4.2.1).
+func (e *CacheEntry) FreshnessLifetime() time.Duration {
+	cc := parseCacheControl(e.Header)
+
+	if lifetime, ok := cc.seconds("s-maxage"); ok {
+		return lifetime
+	}
+
+	if lifetime, ok := cc.seconds("max-age"); ok {
+		return lifetime
+	}
+
+	if expires := e.Header.Get(fiber.HeaderExpires); expires != "" {
+		// Invalid dates mean the response is already expired.
+		if expiresAt, err := http.ParseTime(expires); err == nil && expiresAt.After(e.date()) {
+			return expiresAt.Sub(e.date())
+		}
+
+		return 0
+	}
+
+	if !helpers.Contains(heuristicallyCacheableStatuses, e.StatusCode) {
+		return 0
+	}
+
+	lastModified, err := http.ParseTime(e.Header.Get(fiber.HeaderLastModified))
+	if err != nil || !e.date().After(lastModified) {
+		return 0
+	}
+
+	if lifetime := e.date().Sub(lastModified) / heuristicFreshnessFraction; lifetime < maxHeuristicFreshness {
+		return lifetime
+	}
+
+	return maxHeuristicFreshness
+}
+
+// Age - Time since the response was generated by the origin (RFC 9111, section 4.2.3).
+func (e *CacheEntry) Age(now time.Time) time.Duration {
+	apparentAge := e.ResponseTime.Sub(e.date())
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+
+	ageValue, _ := strconv.ParseInt(e.Header.Get(fiber.HeaderAge), 10, 64)
+	correctedAge := time.Duration(ageValue)*time.Second + e.ResponseTime.Sub(e.RequestTime)
+
+	if correctedAge < apparentAge {
+		correctedAge = apparentAge
+	}
+
+	return correctedAge + now.Sub(e.ResponseTime)
+}
+
+// mustRevalidate - Stale copies may never be served without contacting the origin.
+func (e *CacheEntry) mustRevalidate() bool {
+	cc := parseCacheControl(e.Header)
+
+	// `s-maxage` implies `proxy-revalidate` (RFC 9111, section 5.2.2.10).
+	return cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("s-maxage") || cc.has("no-cache")
+}
+
+// fresh - Checks if the entry can be served without revalidation, as per the client's constraints.
+func (e *CacheEntry) fresh(requestCC cacheControl, now time.Time) bool {
+	if parseCacheControl(e.Header).has("no-cache") {
+		return false
+	}
+
+	age, lifetime := e.Age(now), e.FreshnessLifetime()
+	if age >= lifetime {
+		return false
+	}
+
+	if maxAge, ok := requestCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+
+	if minFresh, ok := requestCC.seconds("min-fresh"); ok && lifetime-age < minFresh {
+		return false
+	}
+
+	return true
+}
+
+// staleWithin - Checks if the entry has been stale for at most the window of a directive (RFC 5861).
+func (e *CacheEntry) staleWithin(window time.Duration, now time.Time) bool {
+	return !e.mustRevalidate() && e.Age(now)-e.FreshnessLifetime() <= window
+}
+
+// staleIfError - Checks if the entry may be served because the origin failed.
+func (e *CacheEntry) staleIfError(requestCC cacheControl, now time.Time) bool {
+	for _, cc := range []cacheControl{requestCC, parseCacheControl(e.Header)} {
+		if window, ok := cc.seconds("stale-if-error"); ok && e.staleWithin(window, now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified - Evaluates the client's own preconditions against the entry (RFC 9110, section 13.1).
+func (e *CacheEntry) notModified(headers http.Header) bool {
+	if e.StatusCode != http.StatusOK {
+		return false
+	}
+
+	if ifNoneMatch := headers.Get(fiber.HeaderIfNoneMatch); ifNoneMatch != "" {
+		etag := e.Header.Get(fiber.HeaderETag)
+
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			if candidate = strings.TrimSpace(candidate); candidate == "*" || (etag != "" && weakETag(candidate) == weakETag(etag)) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	since, err := http.ParseTime(headers.Get(fiber.HeaderIfModifiedSince))
+	if err != nil {
+		return false
+	}
+
+	lastModified, err := http.ParseTime(e.Header.Get(fiber.HeaderLastModified))
+	return err == nil && !lastModified.After(since)
+}
+
+// response - Builds the response sent for the entry.
+func (e *CacheEntry) response(requestHeaders http.Header, now time.Time) *http.Response {
+	headers := e.Header.Clone()
+	headers.Set(fiber.HeaderAge, strconv.FormatInt(int64(e.Age(now)/time.Second), 10))
+
+	if e.notModified(requestHeaders) {
+		return &http.Response{StatusCode: http.StatusNotModified, Header: headers, Body: http.NoBody}
+	}
+
+	return &http.Response{
+		StatusCode:    e.StatusCode,
+		Header:        headers,
+		Body:          e.openBody(),
+		ContentLength: e.bodySize(),
+	}
+}
+
+// CacheFetchFunc - Sends a request upstream.
+type CacheFetchFunc func(c *fiber.Ctx) (*http.Response, error)
+
+// Cache - Shared HTTP cache of a path. A nil cache forwards every request.
+type Cache struct {
+	Settings CacheSettings
+	Store    CacheStore
+
+	mu           sync.Mutex
+	now          func() time.Time
+	revalidating map[string]bool
+}
+
+// NewCache - Creates the cache of a route, opening its store.
+func NewCache(settings CacheSettings, route string) (*Cache, error) {
+	settings = settings.withDefaults()
+
+	cache := &Cache{Settings: settings, now: time.Now, revalidating: map[string]bool{}}
+
+	if settings.Store != DiskCacheStoreType {
+		cache.Store = NewMemoryCacheStore(settings.MaxSize)
+		return cache, nil
+	}
+
+	digest := sha256.Sum256([]byte(route))
+
+	store, err := NewDiskCacheStore(filepath.Join(settings.Directory, hex.EncodeToString(digest[:8])), settings.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.Store = store
+
+	return cache, nil
+}
+
+// Fetch - Serves the request from the cache when possible, fetching (and storing) it otherwise.
+func (cache *Cache) Fetch(c *fiber.Ctx, fetch CacheFetchFunc) (*http.Response, CacheStatus, error) {
+	if cache == nil {
+		response, err := fetch(c)
+		return response, "", err
+	}
+
+	key := cacheKey(c)
+	method := c.Method()
+
+	if method != http.MethodGet && method != http.MethodHead {
+		response, err := fetch(c)
+
+		// Unsafe requests invalidate the stored responses of their target (RFC 9111, section 4.4).
+		if err == nil && !helpers.Contains(safeMethods, method) && response.StatusCode < http.StatusBadRequest {
+			cache.Store.Delete(key)
+		}
+
+		return response, "", err
+	}
+
+	// Range requests are not served from (nor stored in) the cache.
+	if c.Get(fiber.HeaderRange) != "" {
+		response, err := fetch(c)
+		return response, MissCacheStatus, err
+	}
+
+	requestCC := requestCacheControl(c)
+	entry := cache.lookup(c, key)
+	now := cache.now()
+
+	defer entry.release()
+
+	if entry != nil && !requestCC.has("no-cache") {
+		if entry.fresh(requestCC, now) {
+			return entry.response(RequestHeaders(c), now), HitCacheStatus, nil
+		}
+
+		if maxStale, ok := requestCC.maxStale(); ok && entry.staleWithin(maxStale, now) {
+			return entry.response(RequestHeaders(c), now), StaleCacheStatus, nil
+		}
+
+		if window, ok := parseCacheControl(entry.Header).seconds("stale-while-revalidate"); ok && entry.staleWithin(window, now) {
+			cache.revalidateInBackground(c, fetch, key, entry)
+			return entry.response(RequestHeaders(c), now), StaleCacheStatus, nil
+		}
+	}
+
+	if requestCC.has("only-if-cached") {
+		return &http.Response{StatusCode: http.StatusGatewayTimeout, Header: http.Header{}, Body: http.NoBody}, MissCacheStatus, nil
+	}
+
+	if entry == nil {
+		requestTime := cache.now()
+
+		response, err := fetch(c)
+		if err != nil {
+			return nil, MissCacheStatus, err
+		}
+
+		return cache.store(c, key, response, requestTime, requestCC), MissCacheStatus, nil
+	}
+
+	detached, release := detach(c)
+
+	response, served, status, err := cache.revalidate(detached, fetch, key, entry, requestCC)
+	if response == nil {
+		release()
+	} else {
+		response.Body = &releasingBody{ReadCloser: response.Body, release: release}
+	}
+
+	if served != nil {
+		return served.response(RequestHeaders(c), cache.now()), status, nil
+	}
+
+	return response, status, err
+}
+
+// lookup - Stored response selected by the request (nil if there is none).
+func (cache *Cache) lookup(c *fiber.Ctx, key string) *CacheEntry {
+	entry, ok := cache.Store.Get(key)
+	if !ok {
+		return nil
+	}
+
+	if entry.marker() {
+		entry.release()
+
+		if entry, ok = cache.Store.Get(variantKey(key, entry.Vary, RequestHeaders(c))); !ok {
+			return nil
+		}
+	}
+
+	return entry
+}
+
+// revalidate - Sends a conditional request for a stale entry.
+//
+// Returns either the upstream response (stored if possible) or the entry to serve in its place.
+func (cache *Cache) revalidate(detached *fiber.Ctx, fetch CacheFetchFunc, key string, entry *CacheEntry, requestCC cacheControl) (*http.Response, *CacheEntry, CacheStatus, error) {
+	headers := &detached.Request().Header
+	for _, name := range conditionalHeaders {
+		headers.Del(name)
+	}
+
+	if etag := entry.Header.Get(fiber.HeaderETag); etag != "" {
+		headers.Set(fiber.HeaderIfNoneMatch, etag)
+	}
+
+	if lastModified := entry.Header.Get(fiber.HeaderLastModified); lastModified != "" {
+		headers.Set(fiber.HeaderIfModifiedSince, lastModified)
+	}
+
+	requestTime := cache.now()
+
+	response, err := fetch(detached)
+	if err != nil || response.StatusCode >= http.StatusInternalServerError {
+		if entry.staleIfError(requestCC, cache.now()) {
+			discard(response)
+			return nil, entry, StaleCacheStatus, nil
+		}
+
+		return response, nil, MissCacheStatus, err
+	}
+
+	if response.StatusCode != http.StatusNotModified {
+		return cache.store(detached, key, response, requestTime, requestCC), nil, MissCacheStatus, nil
+	}
+
+	discard(response)
+
+	// The stored response is freshened with the metadata of the `304` (RFC 9111, section 4.3.4).
+	freshened := *entry
+	freshened.Header = entry.Header.Clone()
+	freshened.RequestTime = requestTime
+	freshened.ResponseTime = cache.now()
+
+	for name, values := range response.Header {
+		if name != fiber.HeaderContentLength {
+			freshened.Header[name] = values
+		}
+	}
+
+	RemoveHopByHopHeaders(freshened.Header)
+
+	if response.Header.Get(fiber.HeaderDate) == "" {
+		freshened.Header.Set(fiber.HeaderDate, freshened.ResponseTime.UTC().Format(http.TimeFormat))
+	}
+
+	cache.Store.Set(freshened.Key, &freshened)
+
+	return nil, &freshened, HitCacheStatus, nil
+}
+
+// revalidateInBackground - Refreshes a stale entry while it keeps being served (RFC 5861, section 3).
+func (cache *Cache) revalidateInBackground(c *fiber.Ctx, fetch CacheFetchFunc, key string, entry *CacheEntry) {
+	cache.mu.Lock()
+	if cache.revalidating[entry.Key] {
+		cache.mu.Unlock()
+		return
+	}
+
+	cache.revalidating[entry.Key] = true
+	cache.mu.Unlock()
+
+	// The incoming context is recycled once the response is sent, and the entry released.
+	detached, release := detach(c)
+	entry.retain()
+
+	go func() {
+		defer func() {
+			release()
+			entry.release()
+
+			cache.mu.Lock()
+			delete(cache.revalidating, entry.Key)
+			cache.mu.Unlock()
+		}()
+
+		response, _, _, err := cache.revalidate(detached, fetch, key, entry, cacheControl{})
+		if err != nil {
+			logger.Logger.WithFields(logrus.Fields{
+				"request.id": detached.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader),
+				"url":        entry.URL,
+				"error":      err,
+			}).Warn("Background revalidation failed 🗄")
+
+			return
+		}
+
+		if response != nil {
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+	}()
+}
+
+// store - Keeps a copy of the response when it may be reused.
+func (cache *Cache) store(c *fiber.Ctx, key string, response *http.Response, requestTime time.Time, requestCC cacheControl) *http.Response {
+	if !storable(c, response, requestCC) || response.ContentLength > cache.Settings.MaxObjectSize {
+		return response
+	}
+
+	entry := &CacheEntry{
+		URL:          key,
+		Vary:         varyHeaders(response.Header),
+		StatusCode:   response.StatusCode,
+		Header:       response.Header.Clone(),
+		RequestTime:  requestTime,
+		ResponseTime: cache.now(),
+	}
+
+	RemoveHopByHopHeaders(entry.Header)
+
+	if entry.Header.Get(fiber.HeaderDate) == "" {
+		entry.Header.Set(fiber.HeaderDate, entry.ResponseTime.UTC().Format(http.TimeFormat))
+	}
+
+	entry.Key = variantKey(key, entry.Vary, RequestHeaders(c))
+
+	if len(entry.Vary) > 0 {
+		cache.Store.Set(key, &CacheEntry{Key: key, URL: key, Vary: entry.Vary})
+	}
+
+	if store, ok := cache.Store.(StreamingCacheStore); ok {
+		response.Body = store.Stream(entry.Key, entry, response.Body, response.ContentLength, cache.Settings.MaxObjectSize)
+		return response
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, cache.Settings.MaxObjectSize+1))
+	if err != nil || int64(len(body)) > cache.Settings.MaxObjectSize {
+		response.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), response.Body), response.Body}
+
+		return response
+	}
+
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	response.ContentLength = int64(len(body))
+
+	entry.Body = body
+	cache.Store.Set(entry.Key, entry)
+
+	return response
+}
+
+// storable - Checks if a shared cache may store the response (RFC 9111, section 3).
+func storable(c *fiber.Ctx, response *http.Response, requestCC cacheControl) bool {
+	if c.Method() != http.MethodGet || requestCC.has("no-store") {
+		return false
+	}
+
+	if response.StatusCode < http.StatusOK || response.StatusCode == http.StatusPartialContent || response.StatusCode == http.StatusNotModified {
+		return false
+	}
+
+	cc := parseCacheControl(response.Header)
+
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+
+	// Responses setting cookies are meant for a single client.
+	if response.Header.Get(fiber.HeaderSetCookie) != "" {
+		return false
+	}
+
+	if helpers.Contains(varyHeaders(response.Header), "*") {
+		return false
+	}
+
+	if c.Get(fiber.HeaderAuthorization) != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return false
+	}
+
+	explicit := cc.has("max-age") || cc.has("s-maxage") || cc.has("public") || response.Header.Get(fiber.HeaderExpires) != ""
+	if !explicit && !helpers.Contains(heuristicallyCacheableStatuses, response.StatusCode) {
+		return false
+	}
+
+	// There has to be a way to reuse the response: a freshness lifetime or a validator.
+	return explicit || response.Header.Get(fiber.HeaderETag) != "" || response.Header.Get(fiber.HeaderLastModified) != ""
+}
+
+// Purge - Deletes the stored responses matching the purge, returning how many were deleted.
+func (cache *Cache) Purge(purge CachePurge) int {
+	purged := 0
+
+	cache.Store.Range(func(entry *CacheEntry) bool {
+		if purge.Matches(entry, cache.Settings.SurrogateKeyHeader) {
+			cache.Store.Delete(entry.Key)
+
+			if !entry.marker() {
+				purged++
+			}
+		}
+
+		return true
+	})
+
+	return purged
+}
+
+// CachePurge - Selects stored responses to invalidate (a single criterion is allowed).
+type CachePurge struct {
+	// Absolute URL of a response (with every variant).
+	URL string `json:"url" example:"https://example.com/posts?page=1"`
+
+	// Tag listed in the surrogate key header of responses.
+	SurrogateKey string `json:"surrogateKey" example:"posts"`
+
+	// Host of responses, optionally narrowed to a path prefix.
+	Host   string `json:"host" example:"example.com"`
+	Prefix string `json:"prefix" example:"/posts/"`
+}
+
+// Validate - Checks that exactly one criterion was given.
+func (cp CachePurge) Validate() error {
+	criteria := 0
+	for _, criterion := range []string{cp.URL, cp.SurrogateKey, cp.Host} {
+		if criterion != "" {
+			criteria++
+		}
+	}
+
+	switch {
+	case criteria != 1:
+		return errors.New(`exactly one of url, surrogateKey or host is required`)
+	case cp.Prefix != "" && cp.Host == "":
+		return errors.New(`prefix requires a host`)
+	case cp.Prefix != "" && !strings.HasPrefix(cp.Prefix, "/"):
+		return errors.New(`prefix must start with "/"`)
+	case cp.URL != "":
+		if _, err := url.Parse(cp.URL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Matches - Checks if a stored response is selected by the purge.
+func (cp CachePurge) Matches(entry *CacheEntry, surrogateKeyHeader string) bool {
+	switch {
+	case cp.URL != "":
+		target, err := url.Parse(cp.URL)
+		if err != nil {
+			return false
+		}
+
+		if target.Host == "" {
+			// Scheme-less URLs (example.com/posts) are parsed as paths.
+			target, err = url.Parse("http://" + cp.URL)
+			if err != nil {
+				return false
+			}
+		}
+
+		return entry.URL == strings.ToLower(target.Hostname())+target.RequestURI()
+	case cp.SurrogateKey != "":
+		return helpers.Contains(surrogateKeys(entry.Header.Values(surrogateKeyHeader)), cp.SurrogateKey)
+	default:
+		prefix := cp.Prefix
+		if prefix == "" {
+			prefix = "/"
+		}
+
+		return strings.HasPrefix(entry.URL, strings.ToLower(normalizedHostname(cp.Host))+prefix)
+	}
+}
+
+// surrogateKeys - Tags listed (space or comma separated) in surrogate key headers.
+func surrogateKeys(values []string) []string {
+	keys := []string{}
+
+	for _, value := range values {
+		keys = append(keys, strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == ',' })...)
+	}
+
+	return keys
+}
+
+// cacheKey - Primary key of the request (host and URI).
+func cacheKey(c *fiber.Ctx) string {
+	// Absolute-form request targets (GET http://host/path) are keyed like origin-form ones.
+	return strings.ToLower(normalizedHostname(c.Hostname())) + string(c.Request().URI().RequestURI())
+}
+
+// variantKey - Key of the response selected by the values of the `Vary` headers.
+func variantKey(key string, vary []string, headers http.Header) string {
+	if len(vary) == 0 {
+		return key
+	}
+
+	var variant strings.Builder
+	variant.WriteString(key)
+
+	for _, name := range vary {
+		variant.WriteString("\n" + name + ": " + strings.Join(headers.Values(name), ", "))
+	}
+
+	return variant.String()
+}
+
+// varyHeaders - Sorted, canonical names listed in the `Vary` header.
+func varyHeaders(headers http.Header) []string {
+	names := []string{}
+
+	for _, value := range headers.Values(fiber.HeaderVary) {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name)); name != "" && !helpers.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func weakETag(etag string) string { return strings.TrimPrefix(etag, "W/") }
+
+// detach - Copies the request into a context that outlives the handler.
+func detach(c *fiber.Ctx) (*fiber.Ctx, func()) {
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Init(c.Request(), c.Context().RemoteAddr(), nil)
+
+	app := c.App()
+	detached := app.AcquireCtx(fctx)
+
+	if requestID := c.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader); requestID != "" {
+		detached.Set(PxFile.Annotations.HTTPRequestIdHeader, requestID)
+	}
+
+	return detached, func() { app.ReleaseCtx(detached) }
+}
+
+// releasingBody - Releases a detached context once the response body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (rb *releasingBody) Close() error {
+	defer rb.once.Do(rb.release)
+	return rb.ReadCloser.Close()
+}
@@ -0,0 +1,460 @@
+package proxy
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	diskCacheEntryExtension = ".entry"
+	diskCacheTempPrefix     = ".tmp-"
+
+	// Metadata length (uint32) and body size (uint64).
+	diskCacheHeaderSize = 12
+)
+
+// DiskCacheStore - Bounded store keeping responses on disk, evicting the least recently used ones.
+//
+// Each entry is a file holding the sizes of its JSON metadata and body, followed by both.
+// Metadata is indexed in memory when the store is opened, so entries survive restarts.
+// Bodies never sit in memory: they are written while they are sent, and read from the file.
+type DiskCacheStore struct {
+	Directory string
+	MaxSize   int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type diskCacheItem struct {
+	key  string
+	file string
+	size int64
+
+	// Entry without its body.
+	metadata *CacheEntry
+}
+
+// NewDiskCacheStore - Opens (or creates) the store in `directory`.
+func NewDiskCacheStore(directory string, maxSize int64) (*DiskCacheStore, error) {
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, err
+	}
+
+	ds := &DiskCacheStore{Directory: directory, MaxSize: maxSize, lru: list.New(), entries: map[string]*list.Element{}}
+
+	return ds, ds.load()
+}
+
+// load - Indexes the entries left by a previous run (least recently written first).
+func (ds *DiskCacheStore) load() error {
+	files, err := os.ReadDir(ds.Directory)
+	if err != nil {
+		return err
+	}
+
+	type storedFile struct {
+		name     string
+		size     int64
+		modified time.Time
+	}
+
+	stored := []storedFile{}
+
+	for _, file := range files {
+		path := filepath.Join(ds.Directory, file.Name())
+
+		// Writes interrupted by a restart.
+		if strings.HasPrefix(file.Name(), diskCacheTempPrefix) {
+			os.Remove(path)
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil || !info.Mode().IsRegular() || filepath.Ext(file.Name()) != diskCacheEntryExtension {
+			continue
+		}
+
+		stored = append(stored, storedFile{name: path, size: info.Size(), modified: info.ModTime()})
+	}
+
+	sort.Slice(stored, func(i, j int) bool { return stored[i].modified.Before(stored[j].modified) })
+
+	for _, file := range stored {
+		metadata, err := readDiskCacheMetadata(file.name)
+		if err != nil || ds.file(metadata.Key) != file.name {
+			os.Remove(file.name)
+			continue
+		}
+
+		ds.index(&diskCacheItem{key: metadata.Key, file: file.name, size: file.size, metadata: metadata})
+	}
+
+	ds.evict()
+
+	return nil
+}
+
+// Get - Stored entry, whose body is read from its file (kept open until the entry is released).
+func (ds *DiskCacheStore) Get(key string) (*CacheEntry, bool) {
+	ds.mu.Lock()
+	element, ok := ds.entries[key]
+	if ok {
+		ds.lru.MoveToFront(element)
+	}
+	ds.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	file, err := os.Open(element.Value.(*diskCacheItem).file)
+	if err != nil {
+		ds.Delete(key)
+		return nil, false
+	}
+
+	entry, body, err := readDiskCacheEntry(file)
+	if err != nil || entry.Key != key {
+		file.Close()
+		ds.Delete(key)
+		return nil, false
+	}
+
+	body.refs = 1
+	entry.file = body
+
+	return entry, true
+}
+
+func (ds *DiskCacheStore) Set(key string, entry *CacheEntry) {
+	body := ds.Stream(key, entry, entry.openBody(), entry.bodySize(), ds.MaxSize)
+
+	io.Copy(io.Discard, body)
+	body.Close()
+}
+
+// Stream - Stores the entry while its body is read from the returned reader (see `StreamingCacheStore`).
+func (ds *DiskCacheStore) Stream(key string, entry *CacheEntry, body io.ReadCloser, size, limit int64) io.ReadCloser {
+	stored := *entry
+	stored.Key = key
+	stored.Body = nil
+	stored.file = nil
+
+	file, err := os.CreateTemp(ds.Directory, diskCacheTempPrefix+"*")
+	if err != nil {
+		ds.Delete(key)
+		return body
+	}
+
+	writer := &diskCacheWriter{ReadCloser: body, store: ds, entry: &stored, file: file, buffer: bufio.NewWriter(file), expected: size, limit: limit}
+
+	if writer.metadataSize, err = writeDiskCacheMetadata(writer.buffer, &stored); err != nil {
+		writer.abort()
+	}
+
+	return writer
+}
+
+func (ds *DiskCacheStore) Delete(key string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if ds.unindex(key) {
+		os.Remove(ds.file(key))
+	}
+}
+
+// Range - Visits the metadata (without body) of every entry until `visit` returns false.
+func (ds *DiskCacheStore) Range(visit func(entry *CacheEntry) bool) {
+	ds.mu.Lock()
+	entries := make([]*CacheEntry, 0, len(ds.entries))
+	for _, element := range ds.entries {
+		entries = append(entries, element.Value.(*diskCacheItem).metadata)
+	}
+	ds.mu.Unlock()
+
+	for _, entry := range entries {
+		if !visit(entry) {
+			return
+		}
+	}
+}
+
+// Size - Total size of the stored files.
+func (ds *DiskCacheStore) Size() int64 {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	return ds.size
+}
+
+func (ds *DiskCacheStore) file(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return filepath.Join(ds.Directory, hex.EncodeToString(digest[:])+diskCacheEntryExtension)
+}
+
+// index - Adds an item as the most recently used one (replacing a previous one with the same key).
+func (ds *DiskCacheStore) index(item *diskCacheItem) {
+	ds.unindex(item.key)
+
+	ds.entries[item.key] = ds.lru.PushFront(item)
+	ds.size += item.size
+}
+
+func (ds *DiskCacheStore) unindex(key string) bool {
+	element, ok := ds.entries[key]
+	if ok {
+		ds.lru.Remove(element)
+		delete(ds.entries, key)
+		ds.size -= element.Value.(*diskCacheItem).size
+	}
+
+	return ok
+}
+
+func (ds *DiskCacheStore) evict() {
+	for ds.size > ds.MaxSize {
+		key := ds.lru.Back().Value.(*diskCacheItem).key
+
+		ds.unindex(key)
+		os.Remove(ds.file(key))
+	}
+}
+
+// commit - Indexes the temporary file of a complete entry (or discards it if the store cannot hold it).
+func (ds *DiskCacheStore) commit(name string, size int64, entry *CacheEntry) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if size <= ds.MaxSize {
+		if err := os.Rename(name, ds.file(entry.Key)); err == nil {
+			ds.index(&diskCacheItem{key: entry.Key, file: ds.file(entry.Key), size: size, metadata: entry})
+			ds.evict()
+
+			return
+		}
+	}
+
+	// A replacement that cannot be stored still evicts the previous response.
+	os.Remove(name)
+
+	if ds.unindex(entry.Key) {
+		os.Remove(ds.file(entry.Key))
+	}
+}
+
+// diskCacheWriter - Copies a body into the temporary file of an entry while it is read.
+//
+// The entry is stored once the whole body was read, and discarded if it grows beyond
+// the limit or the body is closed early.
+type diskCacheWriter struct {
+	io.ReadCloser
+
+	store  *DiskCacheStore
+	entry  *CacheEntry
+	file   *os.File
+	buffer *bufio.Writer
+
+	metadataSize int64
+
+	// Body size (-1 if unknown) and bytes written so far.
+	expected int64
+	limit    int64
+	written  int64
+	eof      bool
+}
+
+func (w *diskCacheWriter) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+
+	if w.file != nil && n > 0 {
+		w.written += int64(n)
+
+		if _, writeErr := w.buffer.Write(p[:n]); writeErr != nil || w.written > w.limit {
+			w.abort()
+		}
+	}
+
+	switch {
+	case err == io.EOF:
+		w.eof = true
+	case err != nil && w.file != nil:
+		w.abort()
+	}
+
+	return n, err
+}
+
+func (w *diskCacheWriter) Close() error {
+	err := w.ReadCloser.Close()
+
+	if w.file != nil {
+		if w.eof || (w.expected >= 0 && w.written == w.expected) {
+			w.commit()
+		} else {
+			w.abort()
+		}
+	}
+
+	return err
+}
+
+func (w *diskCacheWriter) commit() {
+	err := w.buffer.Flush()
+
+	if err == nil {
+		size := make([]byte, 8)
+		binary.BigEndian.PutUint64(size, uint64(w.written))
+
+		_, err = w.file.WriteAt(size, 4)
+	}
+
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	name := w.file.Name()
+	w.file = nil
+
+	if err != nil {
+		os.Remove(name)
+		w.store.Delete(w.entry.Key)
+		return
+	}
+
+	w.store.commit(name, w.metadataSize+w.written, w.entry)
+}
+
+// abort - Discards the temporary file, along with the previous entry of the key.
+func (w *diskCacheWriter) abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+	w.file = nil
+
+	w.store.Delete(w.entry.Key)
+}
+
+// diskCacheBody - Body of an entry returned by `Get`, read from its open file.
+//
+// The file stays readable even once the entry is replaced or evicted, and is closed with
+// the last of its readers (or the entry, see `CacheEntry.release`).
+type diskCacheBody struct {
+	file   *os.File
+	offset int64
+	size   int64
+	refs   int32
+}
+
+func (b *diskCacheBody) retain() { atomic.AddInt32(&b.refs, 1) }
+
+func (b *diskCacheBody) release() {
+	if atomic.AddInt32(&b.refs, -1) == 0 {
+		b.file.Close()
+	}
+}
+
+// open - Reader of the body, keeping the file open until closed.
+func (b *diskCacheBody) open() io.ReadCloser {
+	b.retain()
+	return &diskCacheBodyReader{SectionReader: io.NewSectionReader(b.file, b.offset, b.size), body: b}
+}
+
+type diskCacheBodyReader struct {
+	*io.SectionReader
+	body *diskCacheBody
+	once sync.Once
+}
+
+func (r *diskCacheBodyReader) Close() error {
+	r.once.Do(r.body.release)
+	return nil
+}
+
+// writeDiskCacheMetadata - Writes the header and metadata of an entry (its body size is set once known),
+// returning their size.
+func writeDiskCacheMetadata(writer io.Writer, entry *CacheEntry) (int64, error) {
+	metadata, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, diskCacheHeaderSize)
+	binary.BigEndian.PutUint32(header, uint32(len(metadata)))
+
+	if _, err := writer.Write(header); err != nil {
+		return 0, err
+	}
+
+	if _, err := writer.Write(metadata); err != nil {
+		return 0, err
+	}
+
+	return int64(diskCacheHeaderSize + len(metadata)), nil
+}
+
+// readDiskCacheMetadata - Decodes the metadata of an entry file.
+func readDiskCacheMetadata(name string) (*CacheEntry, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	entry, _, err := readDiskCacheEntry(file)
+
+	return entry, err
+}
+
+// readDiskCacheEntry - Decodes an entry file, locating its body.
+func readDiskCacheEntry(file *os.File) (*CacheEntry, *diskCacheBody, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := make([]byte, diskCacheHeaderSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, nil, err
+	}
+
+	length := int64(binary.BigEndian.Uint32(header))
+	bodySize := int64(binary.BigEndian.Uint64(header[4:]))
+
+	if length+diskCacheHeaderSize > info.Size() {
+		return nil, nil, errors.New(`corrupted cache entry`)
+	}
+
+	encoded := make([]byte, length)
+	if _, err := file.ReadAt(encoded, diskCacheHeaderSize); err != nil {
+		return nil, nil, err
+	}
+
+	entry := &CacheEntry{}
+	if err := json.Unmarshal(encoded, entry); err != nil {
+		return nil, nil, err
+	}
+
+	offset := diskCacheHeaderSize + length
+	if entry.Key == "" || bodySize < 0 || info.Size() != offset+bodySize {
+		return nil, nil, errors.New(`corrupted cache entry`)
+	}
+
+	return entry, &diskCacheBody{file: file, offset: offset, size: bodySize}, nil
+}
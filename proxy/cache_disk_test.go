@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// storedBody - Reads the body of an entry, releasing it.
+func storedBody(t *testing.T, entry *CacheEntry) string {
+	t.Helper()
+
+	defer entry.release()
+
+	body := entry.openBody()
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	return string(data)
+}
+
+func Test_DiskCacheStore(t *testing.T) {
+	directory := t.TempDir()
+
+	store, err := NewDiskCacheStore(directory, 1<<20)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	header := http.Header{"Etag": {`"v1"`}}
+	store.Set("example.com/a", &CacheEntry{URL: "example.com/a", StatusCode: http.StatusOK, Header: header, Body: []byte("a")})
+	store.Set("example.com/b", &CacheEntry{URL: "example.com/b", StatusCode: http.StatusOK, Body: []byte("b")})
+	store.Delete("example.com/b")
+
+	// Entries survive reopening the store.
+	reopened, err := NewDiskCacheStore(directory, 1<<20)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	entry, ok := reopened.Get("example.com/a")
+	if !ok || entry.Header.Get("ETag") != `"v1"` || entry.Key != "example.com/a" || storedBody(t, entry) != "a" {
+		t.Fatalf(`unexpected entry %+v`, entry)
+	}
+
+	if _, ok := reopened.Get("example.com/b"); ok {
+		t.Errorf(`expected deleted entries to stay deleted`)
+	}
+
+	if reopened.Size() != store.Size() {
+		t.Errorf(`expected size %d but got %d`, store.Size(), reopened.Size())
+	}
+
+	// Range omits bodies.
+	reopened.Range(func(entry *CacheEntry) bool {
+		if entry.URL != "example.com/a" || entry.Body != nil {
+			t.Errorf(`unexpected entry %+v`, entry)
+		}
+		return true
+	})
+}
+
+func Test_DiskCacheStore_Eviction(t *testing.T) {
+	directory := t.TempDir()
+
+	store, _ := NewDiskCacheStore(directory, 1<<20)
+	entry := &CacheEntry{StatusCode: http.StatusOK, Body: make([]byte, 100)}
+
+	store.Set("a", entry)
+	size := store.Size()
+
+	// Room for three entries.
+	store, _ = NewDiskCacheStore(directory, 3*size)
+	store.Set("b", entry)
+	store.Set("c", entry)
+
+	// Reading `a` makes `b` the least recently used entry.
+	store.Get("a")
+	store.Set("d", entry)
+
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if _, ok := store.Get(key); ok != expected {
+			t.Errorf(`expected %s to be stored: %v`, key, expected)
+		}
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(directory, "*")); len(files) != 3 {
+		t.Errorf(`expected evicted files to be removed but got %v`, files)
+	}
+
+	// Reopening with a smaller cap evicts the least recently written entries.
+	for key, age := range map[string]time.Duration{"a": 3 * time.Hour, "c": 2 * time.Hour, "d": time.Hour} {
+		os.Chtimes(store.file(key), time.Now().Add(-age), time.Now().Add(-age))
+	}
+
+	store, _ = NewDiskCacheStore(directory, size)
+	if _, ok := store.Get("d"); !ok || store.Size() != size {
+		t.Errorf(`expected only the latest entry to be kept (size %d)`, store.Size())
+	}
+}
+
+func Test_DiskCacheStore_Stream(t *testing.T) {
+	store, _ := NewDiskCacheStore(t.TempDir(), 1<<20)
+	payload := strings.Repeat("x", 64)
+
+	tests := []struct {
+		name  string
+		size  int64
+		limit int64
+		// Bytes read (until the end if negative).
+		read   int64
+		stored bool
+	}{
+		{name: "whole body", size: -1, limit: 64, read: -1, stored: true},
+		{name: "known size", size: 64, limit: 64, read: 64, stored: true},
+		{name: "closed early", size: -1, limit: 64, read: 32, stored: false},
+		{name: "too large", size: -1, limit: 32, read: -1, stored: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.Set("a", &CacheEntry{StatusCode: http.StatusOK, Body: []byte("previous")})
+
+			body := store.Stream("a", &CacheEntry{StatusCode: http.StatusOK}, io.NopCloser(strings.NewReader(payload)), tt.size, tt.limit)
+
+			// Known sizes are read without reaching the end of the body.
+			expected, reader := payload, io.Reader(body)
+			if tt.read >= 0 {
+				expected, reader = payload[:tt.read], io.LimitReader(body, tt.read)
+			}
+
+			read, _ := io.ReadAll(reader)
+			body.Close()
+
+			if string(read) != expected {
+				t.Errorf(`expected the body to be passed through but got %q`, read)
+			}
+
+			entry, ok := store.Get("a")
+			if ok != tt.stored {
+				t.Fatalf(`expected the entry to be stored: %v`, tt.stored)
+			}
+
+			if ok && storedBody(t, entry) != payload {
+				t.Errorf(`unexpected stored body`)
+			}
+
+			if files, _ := filepath.Glob(filepath.Join(store.Directory, diskCacheTempPrefix+"*")); len(files) != 0 {
+				t.Errorf(`expected temporary files to be removed but got %v`, files)
+			}
+		})
+	}
+}
+
+func Test_DiskCacheStore_OpenFile(t *testing.T) {
+	store, _ := NewDiskCacheStore(t.TempDir(), 1<<20)
+	store.Set("a", &CacheEntry{StatusCode: http.StatusOK, Body: []byte("v1")})
+
+	entry, _ := store.Get("a")
+
+	// Entries being served are not affected by later writes.
+	store.Set("a", &CacheEntry{StatusCode: http.StatusOK, Body: []byte("v2")})
+	if body := storedBody(t, entry); body != "v1" {
+		t.Errorf(`expected the opened body to be served but got %q`, body)
+	}
+
+	entry, _ = store.Get("a")
+	store.Delete("a")
+	if body := storedBody(t, entry); body != "v2" {
+		t.Errorf(`expected the opened body to be served but got %q`, body)
+	}
+}
+
+func Test_DiskCacheStore_OversizedReplacement(t *testing.T) {
+	directory := t.TempDir()
+
+	store, _ := NewDiskCacheStore(directory, 1<<10)
+	store.Set("a", &CacheEntry{StatusCode: http.StatusOK, Body: []byte("v1")})
+	store.Set("a", &CacheEntry{StatusCode: http.StatusOK, Body: make([]byte, 2<<10)})
+
+	if _, ok := store.Get("a"); ok || store.Size() != 0 {
+		t.Errorf(`expected an oversized replacement to evict the previous entry (size %d)`, store.Size())
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(directory, "*")); len(files) != 0 {
+		t.Errorf(`expected the previous file to be removed but got %v`, files)
+	}
+}
+
+func Test_DiskCacheStore_Corruption(t *testing.T) {
+	directory := t.TempDir()
+
+	store, _ := NewDiskCacheStore(directory, 1<<20)
+	store.Set("a", &CacheEntry{StatusCode: http.StatusOK, Body: []byte("body")})
+	store.Set("b", &CacheEntry{StatusCode: http.StatusOK, Body: []byte("body")})
+
+	// Truncated entry and interrupted write.
+	truncated := store.file("a")
+	os.Truncate(truncated, 10)
+	os.WriteFile(filepath.Join(directory, diskCacheTempPrefix+"123"), []byte("partial"), 0o644)
+
+	if _, ok := store.Get("a"); ok {
+		t.Errorf(`expected truncated entries to be discarded`)
+	}
+
+	os.WriteFile(store.file("b"), []byte("garbage"), 0o644)
+
+	store, err := NewDiskCacheStore(directory, 1<<20)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(directory, "*")); len(files) != 0 || store.Size() != 0 {
+		t.Errorf(`expected corrupted and temporary files to be removed but got %v`, files)
+	}
+}
+
+func Test_Cache_DiskStore(t *testing.T) {
+	var requests int
+
+	xy, _ := newCacheTestServer(t, CacheSettings{Store: DiskCacheStoreType, Directory: t.TempDir()}, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("stored"))
+	})
+
+	if _, ok := xy.Caches["example.com/"].Store.(*DiskCacheStore); !ok {
+		t.Fatalf(`expected a disk store`)
+	}
+
+	response, body := fetchCached(t, xy, http.MethodGet, nil)
+	expectCache(t, response, body, http.StatusOK, MissCacheStatus, "stored")
+
+	response, body = fetchCached(t, xy, http.MethodGet, nil)
+	expectCache(t, response, body, http.StatusOK, HitCacheStatus, "stored")
+
+	if requests != 1 {
+		t.Errorf(`expected 1 upstream request but got %d`, requests)
+	}
+}
+
+func Test_Cache_DiskStore_LargeObject(t *testing.T) {
+	var requests int
+
+	// Larger than the memory store can hold.
+	large := strings.Repeat("x", int(2*DefaultCacheMaxObjectSize))
+
+	xy, _ := newCacheTestServer(t, CacheSettings{Store: DiskCacheStoreType, Directory: t.TempDir()}, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", "max-age=60")
+
+		// Flushing forces a chunked response of unknown length.
+		io.WriteString(w, large[:1024])
+		w.(http.Flusher).Flush()
+		io.WriteString(w, large[1024:])
+	})
+
+	response, body := fetchCached(t, xy, http.MethodGet, nil)
+	expectCache(t, response, body, http.StatusOK, MissCacheStatus, large)
+
+	response, body = fetchCached(t, xy, http.MethodGet, nil)
+	expectCache(t, response, body, http.StatusOK, HitCacheStatus, large)
+
+	if requests != 1 {
+		t.Errorf(`expected 1 upstream request but got %d`, requests)
+	}
+}
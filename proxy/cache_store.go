@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"container/list"
+	"io"
+	"sync"
+)
+
+// CacheStore - Keeps stored responses by key.
+type CacheStore interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, entry *CacheEntry)
+	Delete(key string)
+
+	// Visits every entry (bodies may be omitted) until `visit` returns false.
+	Range(visit func(entry *CacheEntry) bool)
+}
+
+// StreamingCacheStore - Store writing bodies while they are sent, instead of holding them in memory.
+type StreamingCacheStore interface {
+	CacheStore
+
+	// Stores `entry` while its body (of `size` bytes, -1 if unknown) is read from the returned reader.
+	// 	- The entry is stored once the whole body was read, and not at all if it exceeds `limit` bytes.
+	// 	- The previous entry of `key` is evicted whether the new one is stored or not.
+	Stream(key string, entry *CacheEntry, body io.ReadCloser, size, limit int64) io.ReadCloser
+}
+
+// MemoryCacheStore - Bounded in-memory store evicting the least recently used entries.
+type MemoryCacheStore struct {
+	MaxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+// NewMemoryCacheStore - Creates an empty store holding up to `maxSize` bytes.
+func NewMemoryCacheStore(maxSize int64) *MemoryCacheStore {
+	return &MemoryCacheStore{MaxSize: maxSize, lru: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (ms *MemoryCacheStore) Get(key string) (*CacheEntry, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	element, ok := ms.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	ms.lru.MoveToFront(element)
+
+	return element.Value.(*memoryCacheItem).entry, true
+}
+
+func (ms *MemoryCacheStore) Set(key string, entry *CacheEntry) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	// A replacement too large to be stored still evicts the previous response.
+	ms.remove(key)
+
+	if entry.Size() > ms.MaxSize {
+		return
+	}
+
+	ms.entries[key] = ms.lru.PushFront(&memoryCacheItem{key: key, entry: entry})
+	ms.size += entry.Size()
+
+	for ms.size > ms.MaxSize {
+		ms.remove(ms.lru.Back().Value.(*memoryCacheItem).key)
+	}
+}
+
+func (ms *MemoryCacheStore) Delete(key string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.remove(key)
+}
+
+func (ms *MemoryCacheStore) Range(visit func(entry *CacheEntry) bool) {
+	ms.mu.Lock()
+	entries := make([]*CacheEntry, 0, len(ms.entries))
+	for _, element := range ms.entries {
+		entries = append(entries, element.Value.(*memoryCacheItem).entry)
+	}
+	ms.mu.Unlock()
+
+	for _, entry := range entries {
+		if !visit(entry) {
+			return
+		}
+	}
+}
+
+// Size - Total size of the stored entries.
+func (ms *MemoryCacheStore) Size() int64 {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.size
+}
+
+func (ms *MemoryCacheStore) remove(key string) {
+	if element, ok := ms.entries[key]; ok {
+		ms.lru.Remove(element)
+		delete(ms.entries, key)
+		ms.size -= element.Value.(*memoryCacheItem).entry.Size()
+	}
+}
@@ -0,0 +1,508 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newCacheTestServer - Caches `example.com` in front of `handler`, with the cache clock under the test's control.
+func newCacheTestServer(t *testing.T, settings CacheSettings, handler http.HandlerFunc) (*Server, *fakeClock) {
+	t.Helper()
+
+	upstream := newTestUpstream(t, "", handler)
+	xy := newTestServer(t, ProxyPath{Upstream: &upstream, Cache: &settings})
+
+	clock := &fakeClock{current: time.Unix(0, 0)}
+	xy.Caches["example.com/"].now = clock.now
+
+	return xy, clock
+}
+
+// fetchCached - Sends a request to `example.com` and returns the response with its body.
+func fetchCached(t *testing.T, xy *Server, method string, headers map[string]string) (*http.Response, string) {
+	t.Helper()
+
+	request := newRequest(method, "/resource")
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := xy.Hosts["example.com"].Fiber.Test(request, -1)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+
+	return response, string(body)
+}
+
+func expectCache(t *testing.T, response *http.Response, body string, status int, cacheStatus CacheStatus, expectedBody string) {
+	t.Helper()
+
+	if response.StatusCode != status || response.Header.Get(XCacheHeader) != string(cacheStatus) || body != expectedBody {
+		t.Errorf(`expected %d %s %q but got %d %s %q`, status, cacheStatus, expectedBody, response.StatusCode, response.Header.Get(XCacheHeader), body)
+	}
+}
+
+func Test_Cache_Freshness(t *testing.T) {
+	var requests int32
+	var etag atomic.Value
+	etag.Store(`"v1"`)
+
+	xy, clock := newCacheTestServer(t, CacheSettings{}, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		current := etag.Load().(string)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", current)
+
+		if r.Header.Get("If-None-Match") == current {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		io.WriteString(w, strings.Trim(current, `"`))
+	})
+
+	response, body := fetchCached(t, xy, http.MethodGet, nil)
+	expectCache(t, response, body, http.StatusOK, MissCacheStatus, "v1")
+
+	clock.advance(30 * time.Second)
+
+	response, body = fetchCached(t, xy, http.MethodGet, nil)
+	expectCache(t, response, body, http.StatusOK, HitCacheStatus, "v1")
+
+	if age := response.Header.Get("Age"); age != "30" {
+		t.Errorf(`expected age 30 but got %s`, age)
+	}
+
+	if count := atomic.LoadInt32(&requests); count != 1 {
+		t.Fatalf(`expected fresh response to be served from the cache but upstream got %d requests`, count)
+	}
+
+	// Stale: revalidated with a conditional request answered with 304.
+	clock.advance(31 * time.Second)
+
+	response, body = fetchCached(t, xy, http.MethodGet, nil)
+	expectCache(t, response, body, http.StatusOK, HitCacheStatus, "v1")
+
+	if count := atomic.LoadInt32(&requests); count != 2 {
+		t.Fatalf(`expected a single revalidation but upstream got %d requests`, count)
+	}
+
+	response, _ = fetchCached(t, xy, http.MethodGet, nil)
+	if response.Header.Get(XCacheHeader) != string(HitCacheStatus) || atomic.LoadInt32(&requests) != 2 {
+		t.Fatalf(`expected revalidated response to be fresh again`)
+	}
+
+	// Stale and modified: replaced by the new representation.
+	etag.Store(`"v2"`)
+	clock.advance(61 * time.Second)
+
+	response, body = fetchCached(t, xy, http.MethodGet, nil)
+	expectCache(t, response, body, http.StatusOK, MissCacheStatus, "v2")
+
+	response, body = fetchCached(t, xy, http.MethodGet, nil)
+	expectCache(t, response, body, http.StatusOK, HitCacheStatus, "v2")
+}
+
+func Test_Cache_ClientConditionalRequests(t *testing.T) {
+	xy, _ := newCacheTestServer(t, CacheSettings{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `W/"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		io.WriteString(w, "v1")
+	})
+
+	fetchCached(t, xy, http.MethodGet, nil)
+
+	tests := []struct {
+		headers map[string]string
+		status  int
+	}{
+		{headers: map[string]string{"If-None-Match": `"v0", "v1"`}, status: http.StatusNotModified},
+		{headers: map[string]string{"If-None-Match": `"v0"`}, status: http.StatusOK},
+		{headers: map[string]string{"If-Modified-Since": "Mon, 02 Jan 2006 15:04:05 GMT"}, status: http.StatusNotModified},
+		{headers: map[string]string{"If-Modified-Since": "Sun, 01 Jan 2006 15:04:05 GMT"}, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if response, _ := fetchCached(t, xy, http.MethodGet, tt.headers); response.StatusCode != tt.status {
+			t.Errorf(`expected %d for %v but got %d`, tt.status, tt.headers, response.StatusCode)
+		}
+	}
+}
+
+func Test_Cache_StaleWhileRevalidate(t *testing.T) {
+	var requests int32
+	revalidated := make(chan struct{}, 1)
+
+	xy, clock := newCacheTestServer(t, CacheSettings{}, func(w http.ResponseWriter, r *http.Request) {
+		count := atomic.AddInt32(&requests, 1)
+
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=30")
+		fmt.Fprintf(w, "v%d", count)
+
+		if count > 1 {
+			revalidated <- struct{}{}
+		}
+	})
+
+	fetchCached(t, xy, http.MethodGet, nil)
+	clock.advance(20 * time.Second)
+
+	response, body := fetchCached(t, xy, http.MethodGet, nil)
+	expectCache(t, response, body, http.StatusOK, StaleCacheStatus, "v1")
+
+	select {
+	case <-revalidated:
+	case <-time.After(5 * time.Second):
+		t.Fatalf(`expected stale response to be revalidated in the background`)
+	}
+
+	// The refreshed response is stored once the background fetch completes.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		response, body = fetchCached(t, xy, http.MethodGet, nil)
+		if body == "v2" || time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	expectCache(t, response, body, http.StatusOK, HitCacheStatus, "v2")
+
+	// Past the window, the client waits for the upstream.
+	clock.advance(time.Minute)
+
+	response, body = fetchCached(t, xy, http.MethodGet, nil)
+	expectCache(t, response, body, http.StatusOK, MissCacheStatus, "v3")
+}
+
+func Test_Cache_StaleIfError(t *testing.T) {
+	var failing int32
+
+	xy, clock := newCacheTestServer(t, CacheSettings{}, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "boom")
+			return
+		}
+
+		w.Header().Set("Cache-Control", "max-age=10, stale-if-error=60")
+		io.WriteString(w, "v1")
+	})
+
+	fetchCached(t, xy, http.MethodGet, nil)
+	atomic.StoreInt32(&failing, 1)
+	clock.advance(30 * time.Second)
+
+	response, body := fetchCached(t, xy, http.MethodGet, nil)
+	expectCache(t, response, body, http.StatusOK, StaleCacheStatus, "v1")
+
+	clock.advance(time.Minute)
+
+	response, body = fetchCached(t, xy, http.MethodGet, nil)
+	expectCache(t, response, body, http.StatusInternalServerError, MissCacheStatus, "boom")
+}
+
+func Test_Cache_Vary(t *testing.T) {
+	var requests int32
+
+	xy, _ := newCacheTestServer(t, CacheSettings{}, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		io.WriteString(w, r.Header.Get("Accept-Language"))
+	})
+
+	for _, cacheStatus := range []CacheStatus{MissCacheStatus, HitCacheStatus} {
+		for _, language := range []string{"en", "fr"} {
+			response, body := fetchCached(t, xy, http.MethodGet, map[string]string{"Accept-Language": language})
+			expectCache(t, response, body, http.StatusOK, cacheStatus, language)
+		}
+	}
+
+	if count := atomic.LoadInt32(&requests); count != 2 {
+		t.Errorf(`expected one upstream request per variant but got %d`, count)
+	}
+}
+
+func Test_Cache_NotStorable(t *testing.T) {
+	tests := []struct {
+		name     string
+		response map[string]string
+		request  map[string]string
+	}{
+		{name: "no-store", response: map[string]string{"Cache-Control": "max-age=60, no-store"}},
+		{name: "private", response: map[string]string{"Cache-Control": "private, max-age=60"}},
+		{name: "set-cookie", response: map[string]string{"Cache-Control": "max-age=60", "Set-Cookie": "session=1"}},
+		{name: "vary *", response: map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}},
+		{name: "no freshness nor validator", response: map[string]string{}},
+		{name: "request no-store", response: map[string]string{"Cache-Control": "max-age=60"}, request: map[string]string{"Cache-Control": "no-store"}},
+		{name: "authorization", response: map[string]string{"Cache-Control": "max-age=60"}, request: map[string]string{"Authorization": "Bearer token"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+
+			xy, _ := newCacheTestServer(t, CacheSettings{}, func(w http.ResponseWriter, r *http.Request) {
+				count := atomic.AddInt32(&requests, 1)
+
+				for name, value := range tt.response {
+					w.Header().Set(name, value)
+				}
+
+				fmt.Fprintf(w, "v%d", count)
+			})
+
+			for _, expected := range []string{"v1", "v2"} {
+				response, body := fetchCached(t, xy, http.MethodGet, tt.request)
+				expectCache(t, response, body, http.StatusOK, MissCacheStatus, expected)
+			}
+		})
+	}
+}
+
+func Test_Cache_RequestDirectives(t *testing.T) {
+	var requests int32
+
+	xy, clock := newCacheTestServer(t, CacheSettings{}, func(w http.ResponseWriter, r *http.Request) {
+		count := atomic.AddInt32(&requests, 1)
+
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, "v%d", count)
+	})
+
+	response, body := fetchCached(t, xy, http.MethodGet, map[string]string{"Cache-Control": "only-if-cached"})
+	expectCache(t, response, body, http.StatusGatewayTimeout, MissCacheStatus, "")
+
+	fetchCached(t, xy, http.MethodGet, nil)
+	clock.advance(30 * time.Second)
+
+	response, body = fetchCached(t, xy, http.MethodGet, map[string]string{"Cache-Control": "max-age=10"})
+	expectCache(t, response, body, http.StatusOK, MissCacheStatus, "v2")
+
+	response, body = fetchCached(t, xy, http.MethodGet, map[string]string{"Pragma": "no-cache"})
+	expectCache(t, response, body, http.StatusOK, MissCacheStatus, "v3")
+
+	clock.advance(90 * time.Second)
+
+	response, body = fetchCached(t, xy, http.MethodGet, map[string]string{"Cache-Control": "max-stale=60"})
+	expectCache(t, response, body, http.StatusOK, StaleCacheStatus, "v3")
+}
+
+func Test_Cache_HeadAndInvalidation(t *testing.T) {
+	var requests int32
+
+	xy, _ := newCacheTestServer(t, CacheSettings{}, func(w http.ResponseWriter, r *http.Request) {
+		count := atomic.AddInt32(&requests, 1)
+
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, "v%d", count)
+	})
+
+	fetchCached(t, xy, http.MethodGet, nil)
+
+	response, body := fetchCached(t, xy, http.MethodHead, nil)
+	expectCache(t, response, body, http.StatusOK, HitCacheStatus, "")
+
+	response, _ = fetchCached(t, xy, http.MethodPost, nil)
+	if response.Header.Get(XCacheHeader) != "" {
+		t.Errorf(`expected unsafe requests to bypass the cache`)
+	}
+
+	response, body = fetchCached(t, xy, http.MethodGet, nil)
+	expectCache(t, response, body, http.StatusOK, MissCacheStatus, "v3")
+}
+
+func Test_Cache_MaxObjectSize(t *testing.T) {
+	large := strings.Repeat("x", 64)
+
+	xy, _ := newCacheTestServer(t, CacheSettings{MaxObjectSize: 32}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+
+		// Flushing forces a chunked response of unknown length.
+		io.WriteString(w, large[:16])
+		w.(http.Flusher).Flush()
+		io.WriteString(w, large[16:])
+	})
+
+	for i := 0; i < 2; i++ {
+		response, body := fetchCached(t, xy, http.MethodGet, nil)
+		expectCache(t, response, body, http.StatusOK, MissCacheStatus, large)
+	}
+}
+
+func Test_CacheEntry_FreshnessLifetime(t *testing.T) {
+	date := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		status   int
+		headers  map[string]string
+		expected time.Duration
+	}{
+		{name: "s-maxage", headers: map[string]string{"Cache-Control": "max-age=60, s-maxage=120"}, expected: 2 * time.Minute},
+		{name: "max-age", headers: map[string]string{"Cache-Control": "max-age=60", "Expires": date.Add(time.Hour).Format(http.TimeFormat)}, expected: time.Minute},
+		{name: "expires", headers: map[string]string{"Expires": date.Add(time.Hour).Format(http.TimeFormat)}, expected: time.Hour},
+		{name: "invalid expires", headers: map[string]string{"Expires": "0"}, expected: 0},
+		{name: "heuristic", headers: map[string]string{"Last-Modified": date.Add(-10 * time.Hour).Format(http.TimeFormat)}, expected: time.Hour},
+		{name: "capped heuristic", headers: map[string]string{"Last-Modified": date.Add(-1000 * time.Hour).Format(http.TimeFormat)}, expected: 24 * time.Hour},
+		{name: "no heuristic for 500", status: http.StatusInternalServerError, headers: map[string]string{"Last-Modified": date.Add(-10 * time.Hour).Format(http.TimeFormat)}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &CacheEntry{StatusCode: http.StatusOK, Header: http.Header{"Date": {date.Format(http.TimeFormat)}}}
+			if tt.status != 0 {
+				entry.StatusCode = tt.status
+			}
+
+			for name, value := range tt.headers {
+				entry.Header.Set(name, value)
+			}
+
+			if lifetime := entry.FreshnessLifetime(); lifetime != tt.expected {
+				t.Errorf(`expected %v but got %v`, tt.expected, lifetime)
+			}
+		})
+	}
+}
+
+func Test_CacheEntry_Age(t *testing.T) {
+	date := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	entry := &CacheEntry{
+		Header:       http.Header{"Date": {date.Format(http.TimeFormat)}, "Age": {"30"}},
+		RequestTime:  date.Add(2 * time.Second),
+		ResponseTime: date.Add(5 * time.Second),
+	}
+
+	// Corrected initial age (30s + 3s response delay) exceeds the apparent age (5s).
+	if age := entry.Age(date.Add(15 * time.Second)); age != 43*time.Second {
+		t.Errorf(`expected 43s but got %v`, age)
+	}
+}
+
+func Test_MemoryCacheStore(t *testing.T) {
+	store := NewMemoryCacheStore(30)
+	entry := func(key string) *CacheEntry { return &CacheEntry{Key: key, Body: []byte("123456789")} }
+
+	store.Set("a", entry("a"))
+	store.Set("b", entry("b"))
+	store.Set("c", entry("c"))
+
+	// Reading `a` makes `b` the least recently used entry.
+	store.Get("a")
+	store.Set("d", entry("d"))
+
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if _, ok := store.Get(key); ok != expected {
+			t.Errorf(`expected %s to be stored: %v`, key, expected)
+		}
+	}
+
+	if size := store.Size(); size != 30 {
+		t.Errorf(`expected size 30 but got %d`, size)
+	}
+
+	store.Delete("a")
+	store.Set("huge", &CacheEntry{Body: make([]byte, 64)})
+
+	if size := store.Size(); size != 20 {
+		t.Errorf(`expected oversized entries to be ignored but got size %d`, size)
+	}
+
+	store.Set("c", &CacheEntry{Key: "c", Body: make([]byte, 64)})
+
+	if _, ok := store.Get("c"); ok || store.Size() != 10 {
+		t.Errorf(`expected an oversized replacement to evict the previous entry (size %d)`, store.Size())
+	}
+}
+
+func Test_Cache_Purge(t *testing.T) {
+	xy, _ := newCacheTestServer(t, CacheSettings{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		if strings.HasPrefix(r.URL.Path, "/posts/") {
+			w.Header().Set("Surrogate-Key", "posts post-"+strings.TrimPrefix(r.URL.Path, "/posts/"))
+		}
+		w.Header().Set("Vary", "Accept")
+		io.WriteString(w, r.URL.Path)
+	})
+
+	app := fiber.New()
+	app.Post("/admin/cache/purge", xy.cachePurgeHandler)
+
+	populate := func() {
+		for _, target := range []string{"/posts/1", "/posts/2", "/users/1"} {
+			for _, accept := range []string{"text/plain", "application/json"} {
+				request := newRequest(http.MethodGet, target)
+				request.Header.Set("Accept", accept)
+				xy.Hosts["example.com"].Fiber.Test(request, -1)
+			}
+		}
+	}
+
+	purge := func(body string) (int, map[string]any) {
+		request := httptest.NewRequest(http.MethodPost, "http://example.com/admin/cache/purge", strings.NewReader(body))
+
+		response, err := app.Test(request, -1)
+		if err != nil {
+			t.Fatalf(`unexpected error %v`, err)
+		}
+
+		result := map[string]any{}
+		json.NewDecoder(response.Body).Decode(&result)
+
+		return response.StatusCode, result
+	}
+
+	for body, expected := range map[string]float64{
+		// Every variant of the URL.
+		`{"url": "https://example.com:8443/posts/1"}`:  2,
+		`{"url": "example.com/users/1"}`:               2,
+		`{"surrogateKey": "post-2"}`:                   2,
+		`{"surrogateKey": "posts"}`:                    4,
+		`{"host": "example.com", "prefix": "/posts/"}`: 4,
+		`{"host": "EXAMPLE.com"}`:                      6,
+		`{"host": "example.org"}`:                      0,
+	} {
+		populate()
+
+		status, result := purge(body)
+		if status != http.StatusOK || result["purged"] != expected {
+			t.Errorf(`expected %s to purge %v responses but got %d %v`, body, expected, status, result)
+		}
+
+		// Purged responses are fetched again.
+		request := newRequest(http.MethodGet, "/posts/1")
+		request.Header.Set("Accept", "text/plain")
+		response, _ := xy.Hosts["example.com"].Fiber.Test(request, -1)
+
+		purged := body != `{"url": "example.com/users/1"}` && body != `{"surrogateKey": "post-2"}` && body != `{"host": "example.org"}`
+		if (response.Header.Get(XCacheHeader) == string(MissCacheStatus)) != purged {
+			t.Errorf(`expected %s to purge /posts/1: %v`, body, purged)
+		}
+	}
+
+	for _, body := range []string{`{}`, `{"url": "example.com/", "host": "example.com"}`, `{"prefix": "/posts"}`, `{"host": "example.com", "prefix": "posts"}`, `nope`} {
+		if status, _ := purge(body); status != http.StatusBadRequest {
+			t.Errorf(`expected %s to be rejected but got %d`, body, status)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+
+	"github.com/cleopatrio/proxy/helpers"
+	"github.com/cleopatrio/proxy/logger"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultCoalescingMaxBodySize int64 = 1 << 20
+
+// CoalescingSettings - Shares a single upstream fetch among identical concurrent requests.
+//
+// Only GET and HEAD requests are collapsed. Conditional, range and credentialed
+// (Authorization or Cookie) requests are forwarded on their own unless the credentials
+// are listed in `vary`.
+type CoalescingSettings struct {
+	// Request headers distinguishing otherwise identical requests.
+	Vary []string `yaml:"vary" example:"[Accept, Accept-Encoding]"`
+
+	// Larger responses are not shared, waiters fetch them on their own (in bytes).
+	MaxBodySize int64 `yaml:"maxBodySize" example:"1048576"`
+}
+
+func (cs CoalescingSettings) withDefaults() CoalescingSettings {
+	if cs.MaxBodySize <= 0 {
+		cs.MaxBodySize = DefaultCoalescingMaxBodySize
+	}
+
+	cs.Vary = helpers.Map(cs.Vary, func(_ int, name string) string { return textproto.CanonicalMIMEHeaderKey(name) })
+
+	return cs
+}
+
+// CoalescingReport - Counters of a coalescer.
+type CoalescingReport struct {
+	// Requests eligible for collapsing.
+	Requests int64 `json:"requests"`
+
+	// Upstream fetches made on behalf of eligible requests.
+	Fetches int64 `json:"fetches"`
+
+	// Requests served by the fetch of another request.
+	Collapsed int64 `json:"collapsed"`
+}
+
+// Coalescer - Collapses identical concurrent requests of a path. A nil coalescer forwards every request.
+type Coalescer struct {
+	Settings CoalescingSettings
+
+	// Guards the calls in flight and the counters.
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+
+	requests  int64
+	fetches   int64
+	collapsed int64
+}
+
+// coalescedCall - Upstream fetch in flight, shared by its waiters once done.
+type coalescedCall struct {
+	done chan struct{}
+
+	response *http.Response
+	body     []byte
+	err      error
+
+	// The response was too large to be shared.
+	unshared bool
+}
+
+// NewCoalescer - Creates a coalescer with no fetch in flight.
+func NewCoalescer(settings CoalescingSettings) *Coalescer {
+	return &Coalescer{Settings: settings.withDefaults(), calls: map[string]*coalescedCall{}}
+}
+
+// Fetch - Joins the fetch in flight for an identical request, or makes it on behalf of later ones.
+func (co *Coalescer) Fetch(c *fiber.Ctx, fetch CacheFetchFunc) (*http.Response, error) {
+	if co == nil || !co.eligible(c) {
+		return fetch(c)
+	}
+
+	key := co.key(c)
+
+	co.mu.Lock()
+	co.requests++
+
+	if call, ok := co.calls[key]; ok {
+		co.mu.Unlock()
+
+		<-call.done
+
+		co.mu.Lock()
+		if call.unshared {
+			co.fetches++
+		} else {
+			co.collapsed++
+		}
+		co.mu.Unlock()
+
+		if call.unshared {
+			return fetch(c)
+		}
+
+		logger.Logger.WithFields(logrus.Fields{
+			"request.id": c.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader),
+			"path":       c.Path(),
+		}).Debug("Collapsed request into an in-flight fetch 🔗")
+
+		return call.share(), call.err
+	}
+
+	call := &coalescedCall{done: make(chan struct{})}
+	co.calls[key] = call
+	co.fetches++
+	co.mu.Unlock()
+
+	response, err := fetch(c)
+	response = call.settle(response, err, co.Settings.MaxBodySize)
+
+	co.mu.Lock()
+	delete(co.calls, key)
+	co.mu.Unlock()
+
+	close(call.done)
+
+	return response, err
+}
+
+// Report - Current counters.
+func (co *Coalescer) Report() CoalescingReport {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+
+	return CoalescingReport{
+		Requests:  co.requests,
+		Fetches:   co.fetches,
+		Collapsed: co.collapsed,
+	}
+}
+
+// eligible - Checks if the response to a request may be shared with identical ones.
+func (co *Coalescer) eligible(c *fiber.Ctx) bool {
+	if method := c.Method(); method != http.MethodGet && method != http.MethodHead {
+		return false
+	}
+
+	for _, name := range append([]string{fiber.HeaderRange}, conditionalHeaders...) {
+		if c.Get(name) != "" {
+			return false
+		}
+	}
+
+	for _, name := range []string{fiber.HeaderAuthorization, fiber.HeaderCookie} {
+		if c.Get(name) != "" && !helpers.Contains(co.Settings.Vary, name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// key - Method, URL and configured vary headers of the request.
+func (co *Coalescer) key(c *fiber.Ctx) string {
+	key := strings.Builder{}
+	key.WriteString(c.Method() + " " + cacheKey(c))
+
+	headers := RequestHeaders(c)
+	for _, name := range co.Settings.Vary {
+		key.WriteString("\n" + name + ": " + strings.Join(headers.Values(name), ", "))
+	}
+
+	return key.String()
+}
+
+// settle - Buffers the response for the waiters, returning the leader's own copy.
+//
+// Waiters fetch on their own when the response is too large or meant for a single client.
+func (call *coalescedCall) settle(response *http.Response, err error, maxBodySize int64) *http.Response {
+	if err != nil {
+		call.err = err
+		return response
+	}
+
+	if !shareable(response) {
+		call.unshared = true
+		return response
+	}
+
+	body, readErr := io.ReadAll(io.LimitReader(response.Body, maxBodySize+1))
+	if readErr != nil || int64(len(body)) > maxBodySize {
+		call.unshared = true
+
+		response.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), response.Body), response.Body}
+
+		return response
+	}
+
+	response.Body.Close()
+
+	call.response = response
+	call.body = body
+
+	return call.share()
+}
+
+// shareable - Checks if a response may be sent to other clients (like the cache, responses
+// setting cookies or marked `private` or `no-store` are not).
+func shareable(response *http.Response) bool {
+	cc := parseCacheControl(response.Header)
+
+	return response.Header.Get(fiber.HeaderSetCookie) == "" && !cc.has("private") && !cc.has("no-store")
+}
+
+// share - Copy of the buffered response (each request gets its own headers and body).
+func (call *coalescedCall) share() *http.Response {
+	if call.response == nil {
+		return nil
+	}
+
+	response := *call.response
+	response.Header = call.response.Header.Clone()
+	response.Body = io.NopCloser(bytes.NewReader(call.body))
+	response.ContentLength = int64(len(call.body))
+
+	return &response
+}
@@ -0,0 +1,274 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// coalesceConcurrently - Sends `requests` concurrently, releasing the upstream only
+// once the `eligible` ones have reached the coalescer.
+func coalesceConcurrently(t *testing.T, xy *Server, release chan struct{}, eligible int, requests []*http.Request) []string {
+	t.Helper()
+
+	bodies := make([]string, len(requests))
+	wg := sync.WaitGroup{}
+
+	for i, request := range requests {
+		wg.Add(1)
+
+		go func(i int, request *http.Request) {
+			defer wg.Done()
+
+			response, err := xy.Hosts["example.com"].Fiber.Test(request, -1)
+			if err != nil {
+				t.Errorf(`unexpected error %v`, err)
+				return
+			}
+
+			body, _ := io.ReadAll(response.Body)
+			bodies[i] = string(body)
+		}(i, request)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); xy.Coalescers["example.com/"].Report().Requests < int64(eligible); {
+		if time.Now().After(deadline) {
+			t.Fatalf(`requests did not reach the coalescer`)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	wg.Wait()
+
+	return bodies
+}
+
+func newCoalescingTestServer(t *testing.T, settings CoalescingSettings, handler func(w http.ResponseWriter, r *http.Request)) (*Server, chan struct{}, *int32) {
+	t.Helper()
+
+	var fetches int32
+	release := make(chan struct{})
+
+	upstream := newTestUpstream(t, "", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		handler(w, r)
+	})
+
+	return newTestServer(t, ProxyPath{Upstream: &upstream, Coalescing: &settings}), release, &fetches
+}
+
+func Test_Coalescer(t *testing.T) {
+	xy, release, fetches := newCoalescingTestServer(t, CoalescingSettings{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", "a")
+		io.WriteString(w, "shared")
+	})
+
+	requests := []*http.Request{}
+	for i := 0; i < 10; i++ {
+		requests = append(requests, newRequest(http.MethodGet, "/resource?page=1"))
+	}
+
+	for _, body := range coalesceConcurrently(t, xy, release, 10, requests) {
+		if body != "shared" {
+			t.Errorf(`expected the shared response but got %q`, body)
+		}
+	}
+
+	if *fetches != 1 {
+		t.Errorf(`expected 1 upstream fetch but got %d`, *fetches)
+	}
+
+	if report := xy.Coalescers["example.com/"].Report(); report != (CoalescingReport{Requests: 10, Fetches: 1, Collapsed: 9}) {
+		t.Errorf(`unexpected report %+v`, report)
+	}
+
+	// Later requests are not collapsed into finished fetches.
+	if status, body := send(t, xy, newRequest(http.MethodGet, "/resource?page=1")); status != http.StatusOK || body != "shared" || *fetches != 2 {
+		t.Errorf(`expected a new fetch but got %d %q after %d fetches`, status, body, *fetches)
+	}
+}
+
+func Test_Coalescer_Key(t *testing.T) {
+	xy, release, fetches := newCoalescingTestServer(t, CoalescingSettings{Vary: []string{"accept", "authorization"}}, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("Accept"))
+	})
+
+	request := func(method, target string, headers ...string) *http.Request {
+		request := newRequest(method, target)
+		for i := 0; i < len(headers); i += 2 {
+			request.Header.Set(headers[i], headers[i+1])
+		}
+		return request
+	}
+
+	requests := []*http.Request{
+		request(http.MethodGet, "/a", "Accept", "text/plain"),
+		request(http.MethodGet, "/a", "Accept", "text/plain", "User-Agent", "other"),
+		request(http.MethodGet, "/a", "Accept", "application/json"),
+		request(http.MethodGet, "/b", "Accept", "text/plain"),
+		request(http.MethodHead, "/a", "Accept", "text/plain"),
+		request(http.MethodGet, "/a", "Accept", "text/plain", "Authorization", "Bearer a"),
+	}
+
+	// Not eligible.
+	requests = append(requests,
+		request(http.MethodPost, "/a", "Accept", "text/plain"),
+		request(http.MethodGet, "/a", "Accept", "text/plain", "If-None-Match", `"v1"`),
+		request(http.MethodGet, "/a", "Accept", "text/plain", "Range", "bytes=0-1"),
+		request(http.MethodGet, "/a", "Accept", "text/plain", "Cookie", "session=a"),
+	)
+
+	bodies := coalesceConcurrently(t, xy, release, 6, requests)
+
+	if *fetches != 9 {
+		t.Errorf(`expected 9 upstream fetches but got %d`, *fetches)
+	}
+
+	if bodies[0] != "GET /a text/plain" || bodies[1] != bodies[0] || bodies[2] != "GET /a application/json" || bodies[3] != "GET /b text/plain" {
+		t.Errorf(`unexpected bodies %q`, bodies)
+	}
+
+	if report := xy.Coalescers["example.com/"].Report(); report != (CoalescingReport{Requests: 6, Fetches: 5, Collapsed: 1}) {
+		t.Errorf(`unexpected report %+v`, report)
+	}
+}
+
+func Test_Coalescer_LargeResponses(t *testing.T) {
+	xy, release, fetches := newCoalescingTestServer(t, CoalescingSettings{MaxBodySize: 4}, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "too large")
+	})
+
+	bodies := coalesceConcurrently(t, xy, release, 3, []*http.Request{
+		newRequest(http.MethodGet, "/resource"),
+		newRequest(http.MethodGet, "/resource"),
+		newRequest(http.MethodGet, "/resource"),
+	})
+
+	if strings.Join(bodies, ",") != "too large,too large,too large" {
+		t.Errorf(`unexpected bodies %q`, bodies)
+	}
+
+	if *fetches != 3 {
+		t.Errorf(`expected waiters to fetch on their own but got %d fetches`, *fetches)
+	}
+
+	if report := xy.Coalescers["example.com/"].Report(); report != (CoalescingReport{Requests: 3, Fetches: 3}) {
+		t.Errorf(`unexpected report %+v`, report)
+	}
+}
+
+func Test_Coalescer_PrivateResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+	}{
+		{name: "cookie", header: fiber.HeaderSetCookie, value: "session=%d"},
+		{name: "private", header: fiber.HeaderCacheControl, value: "private, max-age=%d"},
+		{name: "no-store", header: fiber.HeaderCacheControl, value: "no-store, max-age=%d"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var served int32
+
+			xy, release, fetches := newCoalescingTestServer(t, CoalescingSettings{}, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(tt.header, fmt.Sprintf(tt.value, atomic.AddInt32(&served, 1)))
+				io.WriteString(w, w.Header().Get(tt.header))
+			})
+
+			bodies := coalesceConcurrently(t, xy, release, 5, []*http.Request{
+				newRequest(http.MethodGet, "/session"),
+				newRequest(http.MethodGet, "/session"),
+				newRequest(http.MethodGet, "/session"),
+				newRequest(http.MethodGet, "/session"),
+				newRequest(http.MethodGet, "/session"),
+			})
+
+			if *fetches != 5 {
+				t.Errorf(`expected every request to fetch on its own but got %d fetches`, *fetches)
+			}
+
+			seen := map[string]bool{}
+			for _, body := range bodies {
+				if seen[body] {
+					t.Errorf(`expected every client to get its own response but got %q`, bodies)
+				}
+
+				seen[body] = true
+			}
+		})
+	}
+}
+
+func Test_Coalescer_Errors(t *testing.T) {
+	coalescer := NewCoalescer(CoalescingSettings{})
+	release := make(chan struct{})
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		_, err := coalescer.Fetch(c, func(c *fiber.Ctx) (*http.Response, error) {
+			<-release
+			return nil, ErrNoAvailableBackend
+		})
+		if err != ErrNoAvailableBackend {
+			t.Errorf(`expected the shared error but got %v`, err)
+		}
+		return c.SendStatus(http.StatusServiceUnavailable)
+	})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.Test(newRequest(http.MethodGet, "/"), -1)
+		}()
+	}
+
+	for coalescer.Report().Requests < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if report := coalescer.Report(); report != (CoalescingReport{Requests: 3, Fetches: 1, Collapsed: 2}) {
+		t.Errorf(`unexpected report %+v`, report)
+	}
+}
+
+func Test_CoalescingHandler(t *testing.T) {
+	upstream := newTestUpstream(t, "a", nil)
+	xy := newTestServer(t, ProxyPath{Upstream: &upstream, Coalescing: &CoalescingSettings{}})
+
+	send(t, xy, newRequest(http.MethodGet, "/"))
+
+	app := fiber.New()
+	app.Get("/admin/coalescing", xy.coalescingHandler)
+
+	response, err := app.Test(newRequest(http.MethodGet, "/admin/coalescing"), -1)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	report := map[string]CoalescingReport{}
+	if err := json.NewDecoder(response.Body).Decode(&report); err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	if report["example.com/"] != (CoalescingReport{Requests: 1, Fetches: 1}) {
+		t.Errorf(`unexpected report %v`, report)
+	}
+}
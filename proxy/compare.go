@@ -0,0 +1,355 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cleopatrio/proxy/helpers"
+	"github.com/cleopatrio/proxy/logger"
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultComparisonMaxBodySize int64 = 1 << 20
+
+// ShadowComparison - Compares the responses of a replay target with the primary ones.
+//
+// Mostly useful with the mirror mode, when the target runs another version of the upstream.
+type ShadowComparison struct {
+	// Response headers compared (only the status and body are compared if empty).
+	Headers []string `yaml:"headers" example:"[Content-Type]"`
+
+	// Fields of JSON bodies left out of the comparison (e.g. timestamps).
+	// 	- Fields are dot-separated keys or array indexes, `*` matches any of them and `**` any number of them.
+	IgnorePaths []string `yaml:"ignorePaths" example:"[meta.timestamp, items.*.id]"`
+
+	// Responses with larger bodies are not compared (defaults to 1MB).
+	MaxBodySize int64 `yaml:"maxBodySize" example:"1048576"`
+
+	// Mismatches are also appended to this JSONL file (only logged if empty).
+	ReportFile string `yaml:"reportFile" example:"shadow-mismatches.jsonl"`
+}
+
+func (sc ShadowComparison) withDefaults() ShadowComparison {
+	if sc.MaxBodySize <= 0 {
+		sc.MaxBodySize = DefaultComparisonMaxBodySize
+	}
+
+	return sc
+}
+
+// ResponseSnapshot - Copy of a response, compared with the responses of replay targets.
+type ResponseSnapshot struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// captureResponse - Copies a response without consuming its body, or returns nil if the body
+// exceeds `limit` bytes.
+func captureResponse(response *http.Response, limit int64) *ResponseSnapshot {
+	body, err := io.ReadAll(io.LimitReader(response.Body, limit+1))
+
+	response.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), response.Body), response.Body}
+
+	if err != nil || int64(len(body)) > limit {
+		return nil
+	}
+
+	return &ResponseSnapshot{Status: response.StatusCode, Header: response.Header.Clone(), Body: body}
+}
+
+// comparedBodyLimit - Largest body compared by any of the targets (0 if none compares responses).
+func comparedBodyLimit(targets []ReplayTarget) int64 {
+	var limit int64
+
+	for _, target := range targets {
+		if target.Compare != nil && target.Compare.withDefaults().MaxBodySize > limit {
+			limit = target.Compare.withDefaults().MaxBodySize
+		}
+	}
+
+	return limit
+}
+
+// Difference - Part of a replayed response that differs from the primary response.
+type Difference struct {
+	// `status`, `header.<name>`, `body` (non-JSON bodies) or `body.<field>`.
+	Field string `json:"field"`
+
+	// Values of both responses (absent if the field is missing).
+	Primary any `json:"primary,omitempty"`
+	Shadow  any `json:"shadow,omitempty"`
+}
+
+// ShadowMismatch - Replayed request whose responses differ.
+type ShadowMismatch struct {
+	ID     string `json:"id"`
+	Target string `json:"target"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+
+	Differences []Difference `json:"differences"`
+
+	ComparedAt time.Time `json:"comparedAt"`
+}
+
+// ComparisonReport - Counters of a shadow comparator.
+type ComparisonReport struct {
+	// Responses compared.
+	Compared   int64 `json:"compared"`
+	Matched    int64 `json:"matched"`
+	Mismatched int64 `json:"mismatched"`
+
+	// Responses left out (e.g. too large, or no primary response was received).
+	Skipped int64 `json:"skipped"`
+}
+
+// ShadowComparator - Compares the responses of a replay target with the primary ones.
+type ShadowComparator struct {
+	Target   string
+	Settings ShadowComparison
+
+	// Mismatch report (nil if disabled).
+	report *jsonLinesFile
+
+	compared   int64
+	matched    int64
+	mismatched int64
+	skipped    int64
+}
+
+// NewShadowComparator - Comparator of the responses of the `target` replay target.
+func NewShadowComparator(target string, settings ShadowComparison) *ShadowComparator {
+	sc := &ShadowComparator{Target: target, Settings: settings.withDefaults()}
+
+	if settings.ReportFile != "" {
+		sc.report = &jsonLinesFile{Path: settings.ReportFile}
+	}
+
+	return sc
+}
+
+// capture - Copies a replayed response for comparison (nil if it cannot be compared).
+func (sc *ShadowComparator) capture(response *http.Response) *ResponseSnapshot {
+	if sc == nil || response == nil {
+		return nil
+	}
+
+	return captureResponse(response, sc.Settings.MaxBodySize)
+}
+
+// Compare - Compares the response of a replayed request with the primary one, returning the differences.
+func (sc *ShadowComparator) Compare(snapshot RequestSnapshot, shadow *ResponseSnapshot) []Difference {
+	if sc == nil {
+		return nil
+	}
+
+	if snapshot.Response == nil || shadow == nil {
+		atomic.AddInt64(&sc.skipped, 1)
+		return nil
+	}
+
+	atomic.AddInt64(&sc.compared, 1)
+
+	differences := sc.Settings.Diff(*snapshot.Response, *shadow)
+	if len(differences) == 0 {
+		atomic.AddInt64(&sc.matched, 1)
+		return nil
+	}
+
+	atomic.AddInt64(&sc.mismatched, 1)
+
+	logger.Logger.WithFields(logrus.Fields{
+		"request.id": snapshot.ID,
+		"target":     sc.Target,
+		"method":     snapshot.Method,
+		"path":       snapshot.Path,
+		"fields":     strings.Join(helpers.Map(differences, func(_ int, d Difference) string { return d.Field }), ", "),
+	}).Warn("Shadow response mismatch 🔀")
+
+	if sc.report != nil {
+		mismatch := ShadowMismatch{
+			ID:          snapshot.ID,
+			Target:      sc.Target,
+			Method:      snapshot.Method,
+			Path:        snapshot.Path,
+			Differences: differences,
+			ComparedAt:  time.Now(),
+		}
+
+		if err := sc.report.write(mismatch); err != nil {
+			logger.Logger.WithFields(logrus.Fields{
+				"request.id": snapshot.ID,
+				"file":       sc.report.Path,
+				"error":      err,
+			}).Error("Unable to write shadow mismatch 🔀")
+		}
+	}
+
+	return differences
+}
+
+// Report - Current counters.
+func (sc *ShadowComparator) Report() ComparisonReport {
+	return ComparisonReport{
+		Compared:   atomic.LoadInt64(&sc.compared),
+		Matched:    atomic.LoadInt64(&sc.matched),
+		Mismatched: atomic.LoadInt64(&sc.mismatched),
+		Skipped:    atomic.LoadInt64(&sc.skipped),
+	}
+}
+
+// Diff - Differences between the primary and the shadow responses.
+//
+// Bodies are compared field by field when both are JSON documents, and byte for byte otherwise.
+func (sc ShadowComparison) Diff(primary, shadow ResponseSnapshot) []Difference {
+	differences := []Difference{}
+
+	if primary.Status != shadow.Status {
+		differences = append(differences, Difference{Field: "status", Primary: primary.Status, Shadow: shadow.Status})
+	}
+
+	for _, name := range sc.Headers {
+		name = http.CanonicalHeaderKey(name)
+
+		expected, actual := strings.Join(primary.Header.Values(name), ", "), strings.Join(shadow.Header.Values(name), ", ")
+		if expected != actual {
+			differences = append(differences, Difference{Field: "header." + name, Primary: expected, Shadow: actual})
+		}
+	}
+
+	expected, expectedErr := decodeJSON(primary.Body)
+	actual, actualErr := decodeJSON(shadow.Body)
+
+	switch {
+	case expectedErr == nil && actualErr == nil:
+		differences = sc.diffJSON(differences, []string{}, expected, actual)
+	case !bytes.Equal(primary.Body, shadow.Body):
+		differences = append(differences, Difference{Field: "body", Primary: string(primary.Body), Shadow: string(shadow.Body)})
+	}
+
+	return differences
+}
+
+// diffJSON - Appends the differences between two decoded JSON values at `field`.
+func (sc ShadowComparison) diffJSON(differences []Difference, field []string, primary, shadow any) []Difference {
+	if sc.ignored(field) {
+		return differences
+	}
+
+	switch expected := primary.(type) {
+	case map[string]any:
+		if actual, ok := shadow.(map[string]any); ok {
+			for _, key := range unionKeys(expected, actual) {
+				differences = sc.diffJSON(differences, append(field[:len(field):len(field)], key), expected[key], actual[key])
+			}
+
+			return differences
+		}
+
+	case []any:
+		if actual, ok := shadow.([]any); ok {
+			for i := 0; i < len(expected) || i < len(actual); i++ {
+				differences = sc.diffJSON(differences, append(field[:len(field):len(field)], strconv.Itoa(i)), element(expected, i), element(actual, i))
+			}
+
+			return differences
+		}
+
+	default:
+		if equalJSON(primary, shadow) {
+			return differences
+		}
+	}
+
+	return append(differences, Difference{Field: strings.Join(append([]string{"body"}, field...), "."), Primary: primary, Shadow: shadow})
+}
+
+// ignored - Checks if a JSON field is left out of the comparison.
+func (sc ShadowComparison) ignored(field []string) bool {
+	for _, pattern := range sc.IgnorePaths {
+		if matchSegments(strings.Split(pattern, "."), field) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodeJSON - Decodes a whole JSON document, keeping numbers as written.
+func decodeJSON(body []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the JSON document")
+	}
+
+	return document, nil
+}
+
+// equalJSON - Compares a JSON scalar with a JSON value (numbers by value, so that `1.0` equals `1`).
+func equalJSON(primary, shadow any) bool {
+	expected, expectedNumber := primary.(json.Number)
+	actual, actualNumber := shadow.(json.Number)
+
+	if expectedNumber && actualNumber {
+		if expected == actual {
+			return true
+		}
+
+		x, xErr := expected.Float64()
+		y, yErr := actual.Float64()
+
+		return xErr == nil && yErr == nil && x == y
+	}
+
+	if expectedNumber || actualNumber {
+		return false
+	}
+
+	// Only called with a scalar primary value, so comparing interfaces cannot panic.
+	return primary == shadow
+}
+
+func unionKeys(primary, shadow map[string]any) []string {
+	keys := []string{}
+
+	for key := range primary {
+		keys = append(keys, key)
+	}
+
+	for key := range shadow {
+		if _, found := primary[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func element(collection []any, i int) any {
+	if i < len(collection) {
+		return collection[i]
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func Test_ShadowComparison_Diff(t *testing.T) {
+	comparison := ShadowComparison{
+		Headers:     []string{"content-type"},
+		IgnorePaths: []string{"meta.timestamp", "items.*.id", "**.etag"},
+	}
+
+	response := func(status int, contentType, body string) ResponseSnapshot {
+		return ResponseSnapshot{Status: status, Header: http.Header{"Content-Type": {contentType}}, Body: []byte(body)}
+	}
+
+	tests := []struct {
+		name    string
+		primary ResponseSnapshot
+		shadow  ResponseSnapshot
+		fields  []string
+	}{
+		{
+			name:    "same response",
+			primary: response(200, "application/json", `{"a": 1, "b": [true, null]}`),
+			shadow:  response(200, "application/json", `{"b":[true,null],"a":1.0}`),
+		},
+		{
+			name:    "status",
+			primary: response(200, "application/json", `{}`),
+			shadow:  response(500, "application/json", `{}`),
+			fields:  []string{"status"},
+		},
+		{
+			name:    "header",
+			primary: response(200, "application/json", `{}`),
+			shadow:  response(200, "text/plain", `{}`),
+			fields:  []string{"header.Content-Type"},
+		},
+		{
+			name:    "ignored fields",
+			primary: response(200, "application/json", `{"meta": {"timestamp": 1, "etag": "a"}, "items": [{"id": 1, "name": "a"}]}`),
+			shadow:  response(200, "application/json", `{"meta": {"timestamp": 2, "etag": "b"}, "items": [{"id": 2, "name": "a"}]}`),
+		},
+		{
+			name:    "changed, missing and added fields",
+			primary: response(200, "application/json", `{"name": "a", "total": 2, "items": [1, 2]}`),
+			shadow:  response(200, "application/json", `{"name": "b", "count": 2, "items": [1, 2, 3]}`),
+			fields:  []string{"body.count", "body.items.2", "body.name", "body.total"},
+		},
+		{
+			name:    "different types",
+			primary: response(200, "application/json", `{"items": []}`),
+			shadow:  response(200, "application/json", `{"items": {}}`),
+			fields:  []string{"body.items"},
+		},
+		{
+			name:    "non-JSON bodies",
+			primary: response(200, "text/plain", `hello`),
+			shadow:  response(200, "text/plain", `world`),
+			fields:  []string{"body"},
+		},
+		{
+			name:    "trailing data",
+			primary: response(200, "application/json", `{} {}`),
+			shadow:  response(200, "application/json", `{}`),
+			fields:  []string{"body"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			differences := comparison.Diff(tt.primary, tt.shadow)
+
+			fields := []string{}
+			for _, difference := range differences {
+				fields = append(fields, difference.Field)
+			}
+
+			if len(fields) != len(tt.fields) {
+				t.Fatalf(`expected differences %v but got %v`, tt.fields, fields)
+			}
+
+			for i := range fields {
+				if fields[i] != tt.fields[i] {
+					t.Errorf(`expected differences %v but got %v`, tt.fields, fields)
+				}
+			}
+		})
+	}
+}
+
+func Test_ShadowComparator_Server(t *testing.T) {
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+		switch r.URL.Path {
+		case "/large":
+			w.Write([]byte(`{"name": "large", "padding": "` + strings.Repeat("x", 64) + `"}`))
+		default:
+			w.Write([]byte(`{"name": "shadow", "at": "later"}`))
+		}
+	}))
+	t.Cleanup(shadow.Close)
+
+	address, _ := url.Parse(shadow.URL)
+	port, _ := strconv.Atoi(address.Port())
+	report := filepath.Join(t.TempDir(), "mismatches.jsonl")
+
+	xy := &Server{DeadLetters: NewDeadLetterFile(filepath.Join(t.TempDir(), "dead-letters.jsonl"))}
+	xy.Proxyfile.Annotations.ReplayRequestsEnabled = true
+	xy.Proxyfile.Spec.Server.Replay = ProxyReplay{ReplayTarget: ReplayTarget{
+		Scheme:  "http",
+		Host:    address.Hostname(),
+		Port:    port,
+		Mode:    MirrorReplayMode,
+		Compare: &ShadowComparison{IgnorePaths: []string{"at"}, MaxBodySize: 64, ReportFile: report},
+	}}
+
+	upstream := newTestUpstream(t, "primary", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+		switch r.URL.Path {
+		case "/large":
+			w.Write([]byte(`{"name": "large", "padding": "` + strings.Repeat("x", 128) + `"}`))
+		case "/same":
+			w.Write([]byte(`{"name": "shadow", "at": "now"}`))
+		default:
+			w.Write([]byte(`{"name": "primary", "at": "now"}`))
+		}
+	})
+	xy.registerRule(ProxyEndpointRule{Host: "example.com", Paths: []ProxyPath{
+		{Path: "/", PathType: PrefixPathType, Upstream: &upstream, EnableReplay: true},
+	}})
+
+	if _, body := send(t, xy, newRequest(http.MethodGet, "/different")); body != `{"name": "primary", "at": "now"}` {
+		t.Errorf(`expected the primary response to be sent back but got %s`, body)
+	}
+
+	send(t, xy, newRequest(http.MethodGet, "/same"))
+
+	if _, body := send(t, xy, newRequest(http.MethodGet, "/large")); len(body) != len(`{"name": "large", "padding": ""}`)+128 {
+		t.Errorf(`expected the whole primary response to be sent back but got %d bytes`, len(body))
+	}
+
+	xy.ReplayDispatcher.Close()
+
+	expected := ComparisonReport{Compared: 2, Matched: 1, Mismatched: 1, Skipped: 1}
+	if counters := xy.Comparators[DefaultReplayTargetName].Report(); counters != expected {
+		t.Errorf(`expected counters %+v but got %+v`, expected, counters)
+	}
+
+	file, err := os.Open(report)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	defer file.Close()
+
+	mismatches := []ShadowMismatch{}
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		mismatch := ShadowMismatch{}
+		if err := json.Unmarshal(scanner.Bytes(), &mismatch); err != nil {
+			t.Fatalf(`unexpected error %v`, err)
+		}
+
+		mismatches = append(mismatches, mismatch)
+	}
+
+	if len(mismatches) != 1 || mismatches[0].Path != "/different" || mismatches[0].Target != DefaultReplayTargetName {
+		t.Fatalf(`expected a single mismatch to be reported but got %+v`, mismatches)
+	}
+
+	if differences := mismatches[0].Differences; len(differences) != 1 || differences[0].Field != "body.name" || differences[0].Primary != "primary" || differences[0].Shadow != "shadow" {
+		t.Errorf(`expected the name to differ but got %+v`, differences)
+	}
+}
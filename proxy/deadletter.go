@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cleopatrio/proxy/logger"
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultDeadLetterFile string = "replay-dead-letters.jsonl"
+
+// DeadLetter - Replayed request that failed on every attempt.
+type DeadLetter struct {
+	Request RequestSnapshot `json:"request"`
+
+	// Name of the replay target.
+	Target string `json:"target"`
+
+	Attempts int `json:"attempts"`
+
+	// Outcome of the last attempt.
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// DeadLetterFile - Appends dead letters to a JSONL file (one letter per line).
+type DeadLetterFile struct{ jsonLinesFile }
+
+// NewDeadLetterFile - Dead letters written to `path` (or the default file).
+func NewDeadLetterFile(path string) *DeadLetterFile {
+	if path == "" {
+		path = DefaultDeadLetterFile
+	}
+
+	return &DeadLetterFile{jsonLinesFile{Path: path}}
+}
+
+// Append - Writes a letter at the end of the file.
+func (df *DeadLetterFile) Append(letter DeadLetter) error {
+	return df.write(letter)
+}
+
+// RedriveReport - Outcome of re-driving a dead-letter file.
+type RedriveReport struct {
+	// Letters replayed successfully.
+	Delivered int `json:"delivered"`
+
+	// Letters that failed again (and were dead-lettered anew).
+	Failed int `json:"failed"`
+
+	// Lines that could not be decoded (kept in the dead-letter file).
+	Invalid int `json:"invalid"`
+}
+
+// Redrive - Replays the letters of a dead-letter file (the configured one if `file` is empty).
+func Redrive(proxyfile Proxyfile, file string) (RedriveReport, error) {
+	if file == "" {
+		file = proxyfile.ReplayConfig().DeadLetterFile
+	}
+
+	xy := &Server{
+		Proxyfile:    proxyfile,
+		ReplayClient: newReplayClient(proxyfile.ServerConfig()),
+		DeadLetters:  NewDeadLetterFile(file),
+	}
+
+	return xy.RedriveDeadLetters()
+}
+
+// RedriveDeadLetters - Replays every letter of the server's dead-letter file again.
+//
+// The file is moved away first: letters failing again, including those dead-lettered
+// meanwhile by a running proxy, end up in a new file at the same path.
+func (xy *Server) RedriveDeadLetters() (RedriveReport, error) {
+	report := RedriveReport{}
+
+	redriving := fmt.Sprintf("%s.redrive-%d", xy.DeadLetters.Path, time.Now().UnixNano())
+	if err := os.Rename(xy.DeadLetters.Path, redriving); err != nil {
+		if os.IsNotExist(err) {
+			return report, nil
+		}
+
+		return report, err
+	}
+
+	file, err := os.Open(redriving)
+	if err != nil {
+		return report, err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64<<20)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		letter := DeadLetter{}
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			logger.Logger.WithFields(logrus.Fields{"file": redriving, "line": line, "error": err}).Warn("Invalid dead letter 📭")
+
+			report.Invalid++
+			if err := xy.DeadLetters.append(append([]byte{}, scanner.Bytes()...)); err != nil {
+				return report, err
+			}
+
+			continue
+		}
+
+		target, found := xy.replayTarget(letter.Target)
+		if !found {
+			logger.Logger.WithFields(logrus.Fields{"file": redriving, "line": line, "target": letter.Target}).Warn("Unknown replay target 📭")
+
+			report.Failed++
+			if err := xy.DeadLetters.append(append([]byte{}, scanner.Bytes()...)); err != nil {
+				return report, err
+			}
+
+			continue
+		}
+
+		if xy.deliverReplay(context.Background(), letter.Request, target) == nil {
+			report.Delivered++
+		} else {
+			report.Failed++
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return report, err
+	}
+
+	return report, os.Remove(redriving)
+}
+
+// replayTarget - Configured replay target named `name` (letters written before targets
+// were named belong to the default one).
+func (xy *Server) replayTarget(name string) (ReplayTarget, bool) {
+	if name == "" {
+		name = DefaultReplayTargetName
+	}
+
+	for _, target := range xy.Proxyfile.ReplayConfig().ReplayTargets() {
+		if target.Name == name {
+			return target, true
+		}
+	}
+
+	return ReplayTarget{}, false
+}
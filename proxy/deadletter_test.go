@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newDeadLetterTestServer - Server replaying to a target failing the first `failures` attempts.
+func newDeadLetterTestServer(t *testing.T, retry *RetryPolicy, failures int32) (*Server, *int32) {
+	t.Helper()
+
+	var attempts int32
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(target.Close)
+
+	address, _ := url.Parse(target.URL)
+	port, _ := strconv.Atoi(address.Port())
+
+	xy := &Server{DeadLetters: NewDeadLetterFile(filepath.Join(t.TempDir(), "dead-letters.jsonl"))}
+	xy.Proxyfile.Annotations.ReplayRequestsEnabled = true
+	xy.Proxyfile.Spec.Server.Replay = ProxyReplay{ReplayTarget: ReplayTarget{Scheme: "http", Host: address.Hostname(), Port: port, Mode: MirrorReplayMode}, Retry: retry}
+
+	return xy, &attempts
+}
+
+func readDeadLetters(t *testing.T, path string) []DeadLetter {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	defer file.Close()
+
+	letters := []DeadLetter{}
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		letter := DeadLetter{}
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			t.Fatalf(`unexpected error %v`, err)
+		}
+
+		letters = append(letters, letter)
+	}
+
+	return letters
+}
+
+func Test_ReplayRequest_Retries(t *testing.T) {
+	snapshot := RequestSnapshot{ID: "request-1", Method: http.MethodPut, Path: "/orders/1", Body: []byte("payload")}
+
+	for _, test := range []struct {
+		name             string
+		retry            *RetryPolicy
+		method           string
+		failures         int32
+		expectedAttempts int32
+		deadLettered     bool
+	}{
+		{name: "no retries", failures: 0, expectedAttempts: 1},
+		{name: "recovers", retry: &RetryPolicy{Attempts: 3, Backoff: time.Millisecond}, failures: 2, expectedAttempts: 3},
+		{name: "exhausted", retry: &RetryPolicy{Attempts: 3, Backoff: time.Millisecond}, failures: 5, expectedAttempts: 3, deadLettered: true},
+		{name: "failures without retries", failures: 1, expectedAttempts: 1, deadLettered: true},
+		{name: "non-idempotent", retry: &RetryPolicy{Attempts: 3, Backoff: time.Millisecond}, method: http.MethodPost, failures: 2, expectedAttempts: 3},
+	} {
+		xy, attempts := newDeadLetterTestServer(t, test.retry, test.failures)
+
+		snapshot := snapshot
+		if test.method != "" {
+			snapshot.Method = test.method
+		}
+
+		err := replaySnapshot(xy, snapshot)
+
+		if *attempts != test.expectedAttempts || (err != nil) != test.deadLettered {
+			t.Errorf(`%s: expected %d attempts but got %d (%v)`, test.name, test.expectedAttempts, *attempts, err)
+		}
+
+		letters := readDeadLetters(t, xy.DeadLetters.Path)
+		if !test.deadLettered {
+			if len(letters) != 0 {
+				t.Errorf(`%s: unexpected dead letters %+v`, test.name, letters)
+			}
+
+			continue
+		}
+
+		if len(letters) != 1 {
+			t.Fatalf(`%s: expected 1 dead letter but got %+v`, test.name, letters)
+		}
+
+		letter := letters[0]
+		if letter.Request.ID != "request-1" || string(letter.Request.Body) != "payload" || letter.Attempts != int(test.expectedAttempts) ||
+			letter.Status != http.StatusServiceUnavailable || letter.Error == "" || letter.FailedAt.IsZero() {
+			t.Errorf(`%s: unexpected dead letter %+v`, test.name, letter)
+		}
+	}
+}
+
+func Test_ReplayRequest_ServerErrors(t *testing.T) {
+	for _, test := range []struct {
+		status           int
+		expectedAttempts int32
+		deadLettered     bool
+	}{
+		{status: http.StatusNotFound, expectedAttempts: 1},
+		{status: http.StatusInternalServerError, expectedAttempts: 1, deadLettered: true},
+		{status: http.StatusBadGateway, expectedAttempts: 2, deadLettered: true},
+	} {
+		var attempts int32
+
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&attempts, 1)
+			w.WriteHeader(test.status)
+		}))
+		t.Cleanup(target.Close)
+
+		address, _ := url.Parse(target.URL)
+		port, _ := strconv.Atoi(address.Port())
+
+		xy := &Server{DeadLetters: NewDeadLetterFile(filepath.Join(t.TempDir(), "dead-letters.jsonl"))}
+		xy.Proxyfile.Annotations.ReplayRequestsEnabled = true
+		xy.Proxyfile.Spec.Server.Replay = ProxyReplay{
+			ReplayTarget: ReplayTarget{Scheme: "http", Host: address.Hostname(), Port: port, Mode: MirrorReplayMode},
+			Retry:        &RetryPolicy{Attempts: 2, Backoff: time.Millisecond},
+		}
+
+		err := replaySnapshot(xy, RequestSnapshot{Method: http.MethodGet, Path: "/"})
+
+		if attempts != test.expectedAttempts || (err != nil) != test.deadLettered {
+			t.Errorf(`%d: expected %d attempts but got %d (%v)`, test.status, test.expectedAttempts, attempts, err)
+		}
+
+		if letters := readDeadLetters(t, xy.DeadLetters.Path); (len(letters) == 1) != test.deadLettered {
+			t.Errorf(`%d: unexpected dead letters %+v`, test.status, letters)
+		}
+	}
+}
+
+func Test_ReplayRequest_Cancelled(t *testing.T) {
+	xy, attempts := newDeadLetterTestServer(t, &RetryPolicy{Attempts: 2, Backoff: time.Hour, MaxBackoff: time.Hour}, 5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := xy.deliverReplay(ctx, RequestSnapshot{ID: "request-1", Method: http.MethodGet}, xy.Proxyfile.ReplayConfig().ReplayTargets()[0])
+
+	if err == nil || *attempts != 1 || time.Since(start) > 5*time.Second {
+		t.Errorf(`expected the backoff to be given up (%d attempts, %v)`, *attempts, err)
+	}
+
+	if letters := readDeadLetters(t, xy.DeadLetters.Path); len(letters) != 1 || letters[0].Attempts != 1 {
+		t.Errorf(`expected the replay to be dead-lettered but got %+v`, letters)
+	}
+}
+
+func Test_ReplayRequest_Unreachable(t *testing.T) {
+	xy := &Server{DeadLetters: NewDeadLetterFile(filepath.Join(t.TempDir(), "dead-letters.jsonl"))}
+	xy.Proxyfile.Annotations.ReplayRequestsEnabled = true
+	xy.Proxyfile.Spec.Server.Replay = ProxyReplay{ReplayTarget: ReplayTarget{Scheme: "http", Host: "127.0.0.1", Port: 1}, Retry: &RetryPolicy{Attempts: 2, Backoff: time.Millisecond}}
+
+	if err := replaySnapshot(xy, RequestSnapshot{ID: "request-1", Method: http.MethodGet}); err == nil {
+		t.Errorf(`expected the replay to fail`)
+	}
+
+	if letters := readDeadLetters(t, xy.DeadLetters.Path); len(letters) != 1 || letters[0].Attempts != 2 || letters[0].Status != 0 || letters[0].Error == "" {
+		t.Errorf(`unexpected dead letters %+v`, letters)
+	}
+}
+
+func Test_RedriveDeadLetters(t *testing.T) {
+	// The first attempt of the re-drive fails again.
+	xy, attempts := newDeadLetterTestServer(t, nil, 1)
+
+	for _, id := range []string{"request-1", "request-2"} {
+		xy.DeadLetters.Append(DeadLetter{Request: RequestSnapshot{ID: id, Method: http.MethodGet, Path: "/" + id}, Attempts: 1})
+	}
+
+	xy.DeadLetters.append([]byte(`{not json`))
+
+	report, err := xy.RedriveDeadLetters()
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	if report != (RedriveReport{Delivered: 1, Failed: 1, Invalid: 1}) || *attempts != 2 {
+		t.Errorf(`unexpected report %+v after %d attempts`, report, *attempts)
+	}
+
+	// Letters failing again and invalid lines are kept.
+	content, _ := os.ReadFile(xy.DeadLetters.Path)
+	letters := []DeadLetter{}
+	lines := 0
+	for scanner := bufio.NewScanner(bytes.NewReader(content)); scanner.Scan(); lines++ {
+		letter := DeadLetter{}
+		if json.Unmarshal(scanner.Bytes(), &letter) == nil {
+			letters = append(letters, letter)
+		}
+	}
+
+	if lines != 2 || len(letters) != 1 || letters[0].Request.ID != "request-1" {
+		t.Errorf(`unexpected dead-letter file %q`, content)
+	}
+
+	if leftovers, _ := filepath.Glob(xy.DeadLetters.Path + ".redrive-*"); len(leftovers) != 0 {
+		t.Errorf(`expected re-driven files to be removed but got %v`, leftovers)
+	}
+
+	// Nothing to re-drive.
+	os.Remove(xy.DeadLetters.Path)
+	if report, err := xy.RedriveDeadLetters(); err != nil || report != (RedriveReport{}) {
+		t.Errorf(`unexpected report %+v (%v)`, report, err)
+	}
+}
@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/cleopatrio/proxy/logger"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// ForwardedHeadersSettings - Controls how the original client is described to upstreams.
+type ForwardedHeadersSettings struct {
+	// Emit X-Forwarded-For, X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Port.
+	XForwarded bool `yaml:"xForwarded"`
+
+	// Emit the standard RFC 7239 Forwarded header.
+	Forwarded bool `yaml:"forwarded"`
+
+	// Forwarding headers sent by these peers (CIDRs) are appended to; all others are replaced.
+	TrustedProxies []string `yaml:"trustedProxies" example:"[10.0.0.0/8]"`
+}
+
+// Forwarding - Resolves the original client and emits forwarding headers.
+//
+// A nil forwarding trusts no proxy and emits no headers.
+type Forwarding struct {
+	Settings ForwardedHeadersSettings
+
+	trustedProxies []*net.IPNet
+}
+
+// NewForwarding - Parses the trusted proxy ranges (single addresses are accepted as well).
+func NewForwarding(settings ForwardedHeadersSettings) *Forwarding {
+	forwarding := &Forwarding{Settings: settings}
+
+	for _, cidr := range settings.TrustedProxies {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			logger.Logger.
+				WithFields(logrus.Fields{"cidr": cidr, "error": err}).
+				Error("Invalid trusted proxy")
+
+			continue
+		}
+
+		forwarding.trustedProxies = append(forwarding.trustedProxies, network)
+	}
+
+	return forwarding
+}
+
+// Trusted - Checks if the address belongs to a trusted proxy.
+func (f *Forwarding) Trusted(address string) bool {
+	if f == nil {
+		return false
+	}
+
+	ip := net.ParseIP(strings.Trim(address, "[]"))
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range f.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP - Address of the original client.
+//
+// Forwarding headers are only honored when sent by a trusted proxy, in which case
+// the right-most untrusted address of the chain is the client.
+func (f *Forwarding) ClientIP(c *fiber.Ctx) string {
+	peer := c.Context().RemoteIP().String()
+	if !f.Trusted(peer) {
+		return peer
+	}
+
+	chain := forwardedForChain(c)
+	for i := len(chain) - 1; i >= 0; i-- {
+		if !f.Trusted(chain[i]) {
+			return chain[i]
+		}
+	}
+
+	if len(chain) > 0 {
+		return chain[0]
+	}
+
+	return peer
+}
+
+// Apply - Sets the forwarding headers of a request sent upstream.
+func (f *Forwarding) Apply(c *fiber.Ctx, headers http.Header) {
+	if f == nil || (!f.Settings.XForwarded && !f.Settings.Forwarded) {
+		return
+	}
+
+	peer := c.Context().RemoteIP().String()
+	trusted := f.Trusted(peer)
+
+	proto := "http"
+	if c.Context().IsTLS() {
+		proto = "https"
+	}
+
+	host := string(c.Request().Host())
+
+	port := ""
+	if address, ok := c.Context().LocalAddr().(*net.TCPAddr); ok {
+		port = fmt.Sprint(address.Port)
+	}
+
+	if f.Settings.XForwarded {
+		f.applyXForwarded(headers, trusted, peer, proto, host, port)
+	} else {
+		for _, name := range []string{fiber.HeaderXForwardedFor, fiber.HeaderXForwardedProto, fiber.HeaderXForwardedHost, "X-Forwarded-Port"} {
+			headers.Del(name)
+		}
+	}
+
+	if f.Settings.Forwarded {
+		element := fmt.Sprintf("for=%s;proto=%s;host=%s", forwardedNode(peer), proto, quoteForwardedValue(host))
+
+		if existing := headers.Values(fiber.HeaderForwarded); trusted && len(existing) > 0 {
+			element = strings.Join(existing, ", ") + ", " + element
+		}
+
+		headers.Set(fiber.HeaderForwarded, element)
+	} else {
+		headers.Del(fiber.HeaderForwarded)
+	}
+}
+
+func (f *Forwarding) applyXForwarded(headers http.Header, trusted bool, peer, proto, host, port string) {
+	forwardedFor := peer
+	if existing := headers.Values(fiber.HeaderXForwardedFor); trusted && len(existing) > 0 {
+		forwardedFor = strings.Join(existing, ", ") + ", " + peer
+	}
+
+	headers.Set(fiber.HeaderXForwardedFor, forwardedFor)
+
+	// A trusted proxy already described the original request.
+	values := map[string]string{
+		fiber.HeaderXForwardedProto: proto,
+		fiber.HeaderXForwardedHost:  host,
+		"X-Forwarded-Port":          port,
+	}
+
+	for name, value := range values {
+		if trusted && headers.Get(name) != "" {
+			continue
+		}
+
+		if value == "" {
+			headers.Del(name)
+		} else {
+			headers.Set(name, value)
+		}
+	}
+}
+
+// forwardedForChain - Client addresses listed by the incoming X-Forwarded-For (or Forwarded) header.
+func forwardedForChain(c *fiber.Ctx) (chain []string) {
+	headers := RequestHeaders(c)
+
+	for _, value := range headers.Values(fiber.HeaderXForwardedFor) {
+		for _, address := range strings.Split(value, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				chain = append(chain, address)
+			}
+		}
+	}
+
+	if len(chain) > 0 {
+		return
+	}
+
+	for _, value := range headers.Values(fiber.HeaderForwarded) {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, node, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(name, "for") {
+					continue
+				}
+
+				node = strings.Trim(node, `"`)
+				if host, _, err := net.SplitHostPort(node); err == nil {
+					node = host
+				}
+
+				chain = append(chain, strings.Trim(node, "[]"))
+			}
+		}
+	}
+
+	return
+}
+
+// forwardedNode - Formats an address as a Forwarded node (IPv6 addresses are bracketed and quoted).
+func forwardedNode(address string) string {
+	if strings.Contains(address, ":") {
+		return `"[` + address + `]"`
+	}
+
+	return address
+}
+
+func quoteForwardedValue(value string) string {
+	if strings.ContainsAny(value, `:;,="`) {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+
+	return value
+}
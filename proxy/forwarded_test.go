@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// newForwardingTestServer - Registers `example.com` with the given forwarding settings.
+func newForwardingTestServer(t *testing.T, settings ForwardedHeadersSettings) *Server {
+	upstream := newTestUpstream(t, "", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(r.Header)
+	})
+
+	xy := &Server{}
+	xy.Proxyfile.Spec.Server.ForwardedHeaders = settings
+	xy.registerRule(ProxyEndpointRule{
+		Host:  "example.com",
+		Paths: []ProxyPath{{Path: "/", PathType: PrefixPathType, Upstream: &upstream}},
+	})
+
+	return xy
+}
+
+func Test_ForwardedHeaders(t *testing.T) {
+	// Requests sent through `fiber.App.Test` come from 0.0.0.0.
+	tests := []struct {
+		name     string
+		settings ForwardedHeadersSettings
+		incoming map[string]string
+		expected map[string]string
+	}{
+		{
+			name:     "disabled",
+			settings: ForwardedHeadersSettings{},
+			incoming: map[string]string{"X-Forwarded-For": "203.0.113.7"},
+			expected: map[string]string{"X-Forwarded-For": "203.0.113.7", "Forwarded": ""},
+		},
+		{
+			name:     "untrusted peer replaces",
+			settings: ForwardedHeadersSettings{XForwarded: true, Forwarded: true, TrustedProxies: []string{"10.0.0.0/8"}},
+			incoming: map[string]string{
+				"X-Forwarded-For":   "203.0.113.7",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "spoofed.com",
+				"Forwarded":         "for=203.0.113.7",
+			},
+			expected: map[string]string{
+				"X-Forwarded-For":   "0.0.0.0",
+				"X-Forwarded-Proto": "http",
+				"X-Forwarded-Host":  "example.com",
+				"Forwarded":         "for=0.0.0.0;proto=http;host=example.com",
+			},
+		},
+		{
+			name:     "trusted peer appends",
+			settings: ForwardedHeadersSettings{XForwarded: true, Forwarded: true, TrustedProxies: []string{"0.0.0.0"}},
+			incoming: map[string]string{
+				"X-Forwarded-For":   "203.0.113.7",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "public.example.com",
+				"Forwarded":         "for=203.0.113.7;proto=https",
+			},
+			expected: map[string]string{
+				"X-Forwarded-For":   "203.0.113.7, 0.0.0.0",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "public.example.com",
+				"Forwarded":         "for=203.0.113.7;proto=https, for=0.0.0.0;proto=http;host=example.com",
+			},
+		},
+		{
+			name:     "forwarded only",
+			settings: ForwardedHeadersSettings{Forwarded: true},
+			incoming: map[string]string{"X-Forwarded-For": "203.0.113.7"},
+			expected: map[string]string{"X-Forwarded-For": "", "Forwarded": "for=0.0.0.0;proto=http;host=example.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xy := newForwardingTestServer(t, tt.settings)
+
+			request := newRequest(http.MethodGet, "/")
+			for name, value := range tt.incoming {
+				request.Header.Set(name, value)
+			}
+
+			_, body := send(t, xy, request)
+
+			headers := http.Header{}
+			if err := json.Unmarshal([]byte(body), &headers); err != nil {
+				t.Fatalf(`unexpected error %v`, err)
+			}
+
+			for name, value := range tt.expected {
+				if headers.Get(name) != value {
+					t.Errorf(`expected %s to be %q but got %q`, name, value, headers.Get(name))
+				}
+			}
+		})
+	}
+}
+
+func Test_Forwarding_ClientIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		trusted  []string
+		headers  map[string]string
+		expected string
+	}{
+		{
+			name:     "untrusted peer",
+			trusted:  []string{"10.0.0.0/8"},
+			headers:  map[string]string{"X-Forwarded-For": "203.0.113.7"},
+			expected: "0.0.0.0",
+		},
+		{
+			name:     "trusted peer",
+			trusted:  []string{"0.0.0.0/32"},
+			headers:  map[string]string{"X-Forwarded-For": "203.0.113.7"},
+			expected: "203.0.113.7",
+		},
+		{
+			name:     "right-most untrusted address",
+			trusted:  []string{"0.0.0.0/32", "10.0.0.0/8"},
+			headers:  map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7, 10.1.1.1"},
+			expected: "203.0.113.7",
+		},
+		{
+			name:     "forwarded header",
+			trusted:  []string{"0.0.0.0/32"},
+			headers:  map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https`},
+			expected: "2001:db8::1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+
+			ctx := &fasthttp.RequestCtx{}
+			for name, value := range tt.headers {
+				ctx.Request.Header.Set(name, value)
+			}
+
+			c := app.AcquireCtx(ctx)
+			defer app.ReleaseCtx(c)
+
+			if ip := NewForwarding(ForwardedHeadersSettings{TrustedProxies: tt.trusted}).ClientIP(c); ip != tt.expected {
+				t.Errorf(`expected %s but got %s`, tt.expected, ip)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// Header rule actions
+	AddHeaderAction    HeaderAction = "add"
+	SetHeaderAction    HeaderAction = "set"
+	RemoveHeaderAction HeaderAction = "remove"
+	RenameHeaderAction HeaderAction = "rename"
+)
+
+// Hop-by-hop headers are meaningful for a single connection and must not be forwarded (RFC 9110, section 7.6.1).
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RequestHeaders - Incoming request headers, keeping every value of repeated headers.
+func RequestHeaders(c *fiber.Ctx) http.Header {
+	headers := http.Header{}
+
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		headers.Add(string(key), string(value))
+	})
+
+	return headers
+}
+
+// ForwardedRequestHeaders - Request headers sent upstream (without hop-by-hop headers).
+func ForwardedRequestHeaders(c *fiber.Ctx) http.Header {
+	return forwardedHeaders(RequestHeaders(c))
+}
+
+// forwardedHeaders - Strips the headers that are not forwarded from incoming request headers.
+func forwardedHeaders(headers http.Header) http.Header {
+	// The upstream host is taken from the request URL.
+	headers.Del(fiber.HeaderHost)
+
+	// "TE: trailers" is the only transfer coding a proxy may forward.
+	trailers := teTrailers(headers)
+	RemoveHopByHopHeaders(headers)
+
+	if trailers {
+		headers.Set(fiber.HeaderTE, "trailers")
+	}
+
+	return headers
+}
+
+// RemoveHopByHopHeaders - Deletes hop-by-hop headers, including the ones listed in `Connection`.
+func RemoveHopByHopHeaders(headers http.Header) {
+	for _, value := range headers.Values(fiber.HeaderConnection) {
+		for _, name := range strings.Split(value, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				headers.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopByHopHeaders {
+		headers.Del(name)
+	}
+}
+
+// CopyResponseHeaders - Copies upstream response headers (without hop-by-hop headers) to the client response.
+func CopyResponseHeaders(c *fiber.Ctx, headers http.Header) {
+	headers = headers.Clone()
+	RemoveHopByHopHeaders(headers)
+
+	// Set by the response body stream.
+	headers.Del(fiber.HeaderContentLength)
+
+	for name, values := range headers {
+		c.Response().Header.Del(name)
+
+		for _, value := range values {
+			c.Response().Header.Add(name, value)
+		}
+	}
+}
+
+func teTrailers(headers http.Header) bool {
+	for _, value := range headers.Values(fiber.HeaderTE) {
+		for _, coding := range strings.Split(value, ",") {
+			if strings.EqualFold(textproto.TrimString(coding), "trailers") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// HeaderAction - Controls how a header rule changes request or response headers.
+type HeaderAction string
+
+// HeaderRule - Changes a header of forwarded requests or of the responses sent back.
+//
+// Values are templates, e.g. `${client.ip}` (see `RequestTemplateData`).
+type HeaderRule struct {
+	Action HeaderAction `yaml:"action" example:"set"`
+
+	// Header affected by the rule.
+	Name string `yaml:"name" example:"X-Client-Ip"`
+
+	// Value used by the `add` and `set` actions.
+	Value string `yaml:"value" example:"${client.ip}"`
+
+	// New header name used by the `rename` action.
+	To string `yaml:"to" example:"X-Original-Path"`
+}
+
+// ApplyHeaderRules - Applies the rules (in order) to `headers`.
+func ApplyHeaderRules(rules []HeaderRule, headers http.Header, data RequestTemplateData) {
+	for _, rule := range rules {
+		switch rule.Action {
+		case AddHeaderAction:
+			headers.Add(rule.Name, expandTemplate(rule.Value, data.Resolve))
+		case SetHeaderAction:
+			headers.Set(rule.Name, expandTemplate(rule.Value, data.Resolve))
+		case RemoveHeaderAction:
+			headers.Del(rule.Name)
+		case RenameHeaderAction:
+			if values := headers.Values(rule.Name); len(values) > 0 && rule.To != "" {
+				values = append([]string{}, values...)
+				headers.Del(rule.Name)
+
+				for _, value := range values {
+					headers.Add(rule.To, value)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_ForwardedRequestHeaders(t *testing.T) {
+	upstream := newTestUpstream(t, "", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(r.Header)
+	})
+
+	xy := newTestServer(t, ProxyPath{Upstreams: []ProxyUpstream{upstream}})
+
+	request := newRequest(http.MethodGet, "/")
+	request.Header.Set("Connection", "keep-alive, X-Connection-Scoped")
+	request.Header.Set("X-Connection-Scoped", "secret")
+	request.Header.Set("Keep-Alive", "timeout=5")
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	request.Header.Set("Te", "trailers, deflate")
+	request.Header.Set("If-Modified-Since", "Wed, 21 Oct 2015 07:28:00 GMT")
+	request.Header.Add("X-Multi", "a")
+	request.Header.Add("X-Multi", "b")
+
+	_, body := send(t, xy, request)
+
+	headers := http.Header{}
+	if err := json.Unmarshal([]byte(body), &headers); err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	for _, name := range []string{"Keep-Alive", "Upgrade", "Proxy-Authorization", "X-Connection-Scoped"} {
+		if value := headers.Get(name); value != "" {
+			t.Errorf(`expected %s to be removed but got %q`, name, value)
+		}
+	}
+
+	if te := headers.Get("Te"); te != "trailers" {
+		t.Errorf(`expected "TE: trailers" but got %q`, te)
+	}
+
+	if values := headers.Values("If-Modified-Since"); len(values) != 1 || values[0] != "Wed, 21 Oct 2015 07:28:00 GMT" {
+		t.Errorf(`expected date to be forwarded untouched but got %q`, values)
+	}
+
+	if values := headers.Values("X-Multi"); len(values) != 2 || values[0] != "a" || values[1] != "b" {
+		t.Errorf(`expected both values of X-Multi but got %q`, values)
+	}
+}
+
+func Test_CopyResponseHeaders(t *testing.T) {
+	upstream := newTestUpstream(t, "", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Header().Set("Location", "/people/2")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("Connection", "X-Upstream-Scoped")
+		w.Header().Set("X-Upstream-Scoped", "secret")
+		w.Header().Add("Set-Cookie", "session=abc; Path=/")
+		w.Header().Add("Set-Cookie", "theme=dark; Expires=Wed, 21 Oct 2015 07:28:00 GMT")
+		w.WriteHeader(http.StatusFound)
+		w.Write([]byte(`{}`))
+	})
+
+	xy := newTestServer(t, ProxyPath{Upstreams: []ProxyUpstream{upstream}})
+
+	response, err := xy.Hosts["example.com"].Fiber.Test(newRequest(http.MethodGet, "/"), -1)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	if response.StatusCode != http.StatusFound {
+		t.Errorf(`expected redirect to be relayed but got %d`, response.StatusCode)
+	}
+
+	expected := map[string]string{
+		"Content-Type":  "application/json",
+		"Cache-Control": "public, max-age=60",
+		"Location":      "/people/2",
+	}
+
+	for name, value := range expected {
+		if response.Header.Get(name) != value {
+			t.Errorf(`expected %s to be %q but got %q`, name, value, response.Header.Get(name))
+		}
+	}
+
+	for _, name := range []string{"Keep-Alive", "X-Upstream-Scoped"} {
+		if value := response.Header.Get(name); value != "" {
+			t.Errorf(`expected %s to be removed but got %q`, name, value)
+		}
+	}
+
+	if cookies := response.Cookies(); len(cookies) != 2 || cookies[0].Name != "session" || cookies[1].Name != "theme" {
+		t.Errorf(`expected both cookies but got %v`, response.Header.Values("Set-Cookie"))
+	}
+}
+
+func Test_ApplyHeaderRules(t *testing.T) {
+	data := RequestTemplateData{
+		ClientIP:    "203.0.113.7",
+		RequestID:   "7f1d",
+		Method:      http.MethodGet,
+		Host:        "example.com",
+		Path:        "/people/1",
+		MatchedPath: "/people",
+		Headers:     http.Header{"X-Tenant": {"acme"}},
+		Query:       map[string][]string{"page": {"2"}},
+	}
+
+	tests := []struct {
+		name     string
+		rules    []HeaderRule
+		headers  http.Header
+		expected http.Header
+	}{
+		{
+			name:     "set",
+			rules:    []HeaderRule{{Action: SetHeaderAction, Name: "X-Client-Ip", Value: "${client.ip}"}},
+			headers:  http.Header{"X-Client-Ip": {"spoofed", "twice"}},
+			expected: http.Header{"X-Client-Ip": {"203.0.113.7"}},
+		},
+		{
+			name:     "add",
+			rules:    []HeaderRule{{Action: AddHeaderAction, Name: "Via", Value: "1.1 proxy"}},
+			headers:  http.Header{"Via": {"1.0 edge"}},
+			expected: http.Header{"Via": {"1.0 edge", "1.1 proxy"}},
+		},
+		{
+			name:     "remove",
+			rules:    []HeaderRule{{Action: RemoveHeaderAction, Name: "Server"}},
+			headers:  http.Header{"Server": {"nginx"}, "Etag": {`"v1"`}},
+			expected: http.Header{"Etag": {`"v1"`}},
+		},
+		{
+			name:     "rename",
+			rules:    []HeaderRule{{Action: RenameHeaderAction, Name: "X-Token", To: "Authorization"}},
+			headers:  http.Header{"X-Token": {"Bearer abc"}},
+			expected: http.Header{"Authorization": {"Bearer abc"}},
+		},
+		{
+			name: "templating",
+			rules: []HeaderRule{{
+				Action: SetHeaderAction,
+				Name:   "X-Debug",
+				Value:  "${request.id} ${request.method} ${request.host}${request.path} ${path.matched} ${header.X-Tenant} ${query.page} ${unknown}",
+			}},
+			headers:  http.Header{},
+			expected: http.Header{"X-Debug": {"7f1d GET example.com/people/1 /people acme 2 "}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ApplyHeaderRules(tt.rules, tt.headers, data)
+
+			if len(tt.headers) != len(tt.expected) {
+				t.Fatalf(`expected %v but got %v`, tt.expected, tt.headers)
+			}
+
+			for name, values := range tt.expected {
+				if got := tt.headers.Values(name); strings.Join(got, "|") != strings.Join(values, "|") {
+					t.Errorf(`expected %s to be %q but got %q`, name, values, got)
+				}
+			}
+		})
+	}
+}
+
+func Test_HeaderRules(t *testing.T) {
+	upstream := newTestUpstream(t, "", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "internal/1.0")
+		w.Header().Set("X-Received-Rule", r.Header.Get("X-Rule"))
+		w.Header().Set("X-Received-Path", r.Header.Get("X-Matched-Path"))
+		w.Header().Set("X-Received-Secret", r.Header.Get("X-Secret"))
+	})
+
+	xy := &Server{}
+	xy.registerRule(ProxyEndpointRule{
+		Host:           "example.com",
+		RequestHeaders: []HeaderRule{{Action: SetHeaderAction, Name: "X-Rule", Value: "${request.host}"}},
+		ResponseHeaders: []HeaderRule{
+			{Action: RemoveHeaderAction, Name: "Server"},
+		},
+		Paths: []ProxyPath{{
+			Path:     "/people",
+			PathType: PrefixPathType,
+			Upstream: &upstream,
+			RequestHeaders: []HeaderRule{
+				{Action: SetHeaderAction, Name: "X-Matched-Path", Value: "${path.matched}"},
+				{Action: RemoveHeaderAction, Name: "X-Secret"},
+			},
+			ResponseHeaders: []HeaderRule{
+				{Action: RenameHeaderAction, Name: "X-Received-Rule", To: "X-Rule"},
+			},
+		}},
+	})
+
+	request := newRequest(http.MethodGet, "/people/1")
+	request.Header.Set("X-Secret", "s3cr3t")
+
+	response, err := xy.Hosts["example.com"].Fiber.Test(request, -1)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	expected := map[string]string{
+		"Server":            "",
+		"X-Received-Rule":   "",
+		"X-Rule":            "example.com",
+		"X-Received-Path":   "/people",
+		"X-Received-Secret": "",
+	}
+
+	for name, value := range expected {
+		if response.Header.Get(name) != value {
+			t.Errorf(`expected %s to be %q but got %q`, name, value, response.Header.Get(name))
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cleopatrio/proxy/logger"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Health check defaults
+	DefaultHealthCheckPath      string        = "/"
+	DefaultHealthCheckInterval  time.Duration = 10 * time.Second
+	DefaultHealthCheckTimeout   time.Duration = 2 * time.Second
+	DefaultHealthyThreshold     int           = 2
+	DefaultUnhealthyThreshold   int           = 3
+	DefaultHealthyStatusMinimum int           = 200
+	DefaultHealthyStatusMaximum int           = 399
+)
+
+// StatusRange - Inclusive range of HTTP status codes.
+type StatusRange struct {
+	Min int `yaml:"min" example:"200"`
+	Max int `yaml:"max" example:"299"`
+}
+
+// Contains - Checks if the status code is within the range.
+func (r StatusRange) Contains(status int) bool { return status >= r.Min && status <= r.Max }
+
+// HealthCheckSettings - Controls how upstream backends are actively probed.
+type HealthCheckSettings struct {
+	// Probes are sent to this path on every backend.
+	Path string `yaml:"path" example:"/health"`
+
+	// Time between two probes of the same backend.
+	Interval time.Duration `yaml:"interval" example:"10s"`
+
+	// Probes taking longer than this are considered failed.
+	Timeout time.Duration `yaml:"timeout" example:"2s"`
+
+	// Probes answering with a status outside of this range are considered failed.
+	ExpectedStatus StatusRange `yaml:"expectedStatus"`
+
+	// Consecutive successful probes required to bring a backend back into rotation.
+	HealthyThreshold int `yaml:"healthyThreshold" example:"2"`
+
+	// Consecutive failed probes required to remove a backend from rotation.
+	UnhealthyThreshold int `yaml:"unhealthyThreshold" example:"3"`
+}
+
+func (hs HealthCheckSettings) withDefaults() HealthCheckSettings {
+	if hs.Path == "" {
+		hs.Path = DefaultHealthCheckPath
+	}
+
+	if hs.Interval <= 0 {
+		hs.Interval = DefaultHealthCheckInterval
+	}
+
+	if hs.Timeout <= 0 {
+		hs.Timeout = DefaultHealthCheckTimeout
+	}
+
+	if hs.ExpectedStatus.Min == 0 && hs.ExpectedStatus.Max == 0 {
+		hs.ExpectedStatus = StatusRange{Min: DefaultHealthyStatusMinimum, Max: DefaultHealthyStatusMaximum}
+	}
+
+	if hs.HealthyThreshold <= 0 {
+		hs.HealthyThreshold = DefaultHealthyThreshold
+	}
+
+	if hs.UnhealthyThreshold <= 0 {
+		hs.UnhealthyThreshold = DefaultUnhealthyThreshold
+	}
+
+	return hs
+}
+
+// BackendHealth - Result of the active health checks of a backend.
+type BackendHealth struct {
+	mu sync.Mutex
+
+	unhealthy int32
+	successes int
+	failures  int
+	lastCheck time.Time
+	lastError string
+}
+
+// Healthy - Checks if the backend is in rotation. Backends start healthy.
+func (h *BackendHealth) Healthy() bool { return atomic.LoadInt32(&h.unhealthy) == 0 }
+
+// record - Updates the health state with a probe result.
+//
+// Returns true if the backend moved in or out of rotation.
+func (h *BackendHealth) record(err error, settings HealthCheckSettings) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastCheck = time.Now()
+
+	if err != nil {
+		h.lastError = err.Error()
+		h.successes = 0
+		h.failures++
+
+		if h.Healthy() && h.failures >= settings.UnhealthyThreshold {
+			atomic.StoreInt32(&h.unhealthy, 1)
+			return true
+		}
+
+		return false
+	}
+
+	h.lastError = ""
+	h.failures = 0
+	h.successes++
+
+	if !h.Healthy() && h.successes >= settings.HealthyThreshold {
+		atomic.StoreInt32(&h.unhealthy, 0)
+		return true
+	}
+
+	return false
+}
+
+// HealthChecker - Periodically probes every backend of a pool.
+type HealthChecker struct {
+	Settings HealthCheckSettings
+	Backends []*Backend
+	TLS      bool
+	Client   *http.Client
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewHealthChecker - Creates a health checker for the given backends.
+func NewHealthChecker(settings HealthCheckSettings, backends []*Backend, tls bool) *HealthChecker {
+	settings = settings.withDefaults()
+
+	return &HealthChecker{
+		Settings: settings,
+		Backends: backends,
+		TLS:      tls,
+		Client:   &http.Client{Timeout: settings.Timeout},
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start - Probes all backends on every interval until `Stop` is called.
+func (hc *HealthChecker) Start() {
+	go func() {
+		ticker := time.NewTicker(hc.Settings.Interval)
+		defer ticker.Stop()
+
+		hc.CheckAll()
+
+		for {
+			select {
+			case <-ticker.C:
+				hc.CheckAll()
+			case <-hc.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop - Stops probing backends.
+func (hc *HealthChecker) Stop() { hc.once.Do(func() { close(hc.stop) }) }
+
+// CheckAll - Probes every backend once and waits for the results.
+func (hc *HealthChecker) CheckAll() {
+	var wg sync.WaitGroup
+
+	for _, backend := range hc.Backends {
+		wg.Add(1)
+
+		go func(backend *Backend) {
+			defer wg.Done()
+			hc.check(backend)
+		}(backend)
+	}
+
+	wg.Wait()
+}
+
+func (hc *HealthChecker) check(backend *Backend) {
+	err := hc.probe(backend)
+	if !backend.Health.record(err, hc.Settings) {
+		return
+	}
+
+	fields := logrus.Fields{
+		"upstream": backend.Upstream.Address(),
+		"path":     hc.Settings.Path,
+		"error":    err,
+	}
+
+	if backend.Health.Healthy() {
+		logger.Logger.WithFields(fields).Info("Upstream is healthy again 💚")
+	} else {
+		logger.Logger.WithFields(fields).Warn("Upstream removed from rotation 🚑")
+	}
+}
+
+func (hc *HealthChecker) probe(backend *Backend) error {
+	probeURL, err := backend.Upstream.URL(hc.Settings.Path, hc.TLS)
+	if err != nil {
+		return err
+	}
+
+	response, err := hc.Client.Get(probeURL.String())
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if !hc.Settings.ExpectedStatus.Contains(response.StatusCode) {
+		return fmt.Errorf(`unexpected health check status %d`, response.StatusCode)
+	}
+
+	return nil
+}
+
+// HealthReport - Health state of a backend as exposed by the admin endpoint.
+type HealthReport struct {
+	Upstream            string    `json:"upstream"`
+	Healthy             bool      `json:"healthy"`
+	ActiveConnections   int64     `json:"activeConnections"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	CircuitState        string    `json:"circuitState"`
+	LastCheck           time.Time `json:"lastCheck,omitempty"`
+	LastError           string    `json:"lastError,omitempty"`
+}
+
+// Report - Snapshot of the backend's health state.
+func (b *Backend) Report() HealthReport {
+	b.Health.mu.Lock()
+	defer b.Health.mu.Unlock()
+
+	return HealthReport{
+		Upstream:            b.Upstream.Address(),
+		Healthy:             b.Health.Healthy(),
+		ActiveConnections:   b.ActiveConnections(),
+		ConsecutiveFailures: b.Health.failures,
+		CircuitState:        string(b.Breaker.State()),
+		LastCheck:           b.Health.lastCheck,
+		LastError:           b.Health.lastError,
+	}
+}
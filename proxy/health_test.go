@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func Test_HealthChecker(t *testing.T) {
+	var status int32 = http.StatusOK
+
+	flaky := newTestUpstream(t, "flaky", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.WriteHeader(int(atomic.LoadInt32(&status)))
+			return
+		}
+
+		io.WriteString(w, "flaky")
+	})
+
+	xy := newTestServer(t, ProxyPath{
+		Upstreams: []ProxyUpstream{flaky, newTestUpstream(t, "stable", nil)},
+		HealthCheck: &HealthCheckSettings{
+			Path:               "/health",
+			ExpectedStatus:     StatusRange{Min: 200, Max: 299},
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 2,
+		},
+	})
+
+	pool := xy.Pools["example.com/"]
+	backend := pool.Backends[0]
+
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+
+	pool.HealthChecker.CheckAll()
+	if !backend.Health.Healthy() {
+		t.Fatalf(`expected backend to stay in rotation before reaching the unhealthy threshold`)
+	}
+
+	pool.HealthChecker.CheckAll()
+	if backend.Health.Healthy() {
+		t.Fatalf(`expected backend to be removed from rotation`)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, body := send(t, xy, newRequest(http.MethodGet, "/")); body != "stable" {
+			t.Errorf(`expected stable but got %s`, body)
+		}
+	}
+
+	atomic.StoreInt32(&status, http.StatusOK)
+
+	pool.HealthChecker.CheckAll()
+	if backend.Health.Healthy() {
+		t.Fatalf(`expected backend to stay out of rotation before reaching the healthy threshold`)
+	}
+
+	pool.HealthChecker.CheckAll()
+	if !backend.Health.Healthy() {
+		t.Fatalf(`expected backend to be back in rotation`)
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 4; i++ {
+		_, body := send(t, xy, newRequest(http.MethodGet, "/"))
+		counts[body]++
+	}
+
+	if counts["flaky"] != 2 || counts["stable"] != 2 {
+		t.Errorf(`expected traffic to be shared again but got %v`, counts)
+	}
+}
+
+func Test_HealthChecker_NoHealthyBackends(t *testing.T) {
+	xy := newTestServer(t, ProxyPath{
+		Upstreams:   []ProxyUpstream{{Host: "127.0.0.1", Port: 1}},
+		HealthCheck: &HealthCheckSettings{UnhealthyThreshold: 1},
+	})
+
+	xy.Pools["example.com/"].HealthChecker.CheckAll()
+
+	if status, _ := send(t, xy, newRequest(http.MethodGet, "/")); status != http.StatusServiceUnavailable {
+		t.Errorf(`expected %d but got %d`, http.StatusServiceUnavailable, status)
+	}
+}
+
+func Test_UpstreamsHandler(t *testing.T) {
+	xy := newTestServer(t, ProxyPath{
+		Upstreams:   []ProxyUpstream{{Host: "127.0.0.1", Port: 1}},
+		HealthCheck: &HealthCheckSettings{UnhealthyThreshold: 1},
+	})
+
+	xy.Pools["example.com/"].HealthChecker.CheckAll()
+
+	app := fiber.New()
+	app.Get("/admin/upstreams", xy.upstreamsHandler)
+
+	response, err := app.Test(newRequest(http.MethodGet, "/admin/upstreams"), -1)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	report := map[string][]HealthReport{}
+	if err := json.NewDecoder(response.Body).Decode(&report); err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	backends := report["example.com/"]
+	if len(backends) != 1 {
+		t.Fatalf(`expected 1 backend but got %v`, report)
+	}
+
+	if backends[0].Upstream != "127.0.0.1:1" || backends[0].Healthy || backends[0].LastError == "" {
+		t.Errorf(`unexpected health report %+v`, backends[0])
+	}
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// jsonLinesFile - Appends JSON documents to a file (one document per line).
+//
+// The file is opened for every line, so it may be moved away while the proxy is running.
+type jsonLinesFile struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// write - Appends a document at the end of the file.
+func (jf *jsonLinesFile) write(document any) error {
+	line, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	return jf.append(line)
+}
+
+func (jf *jsonLinesFile) append(line []byte) error {
+	jf.mu.Lock()
+	defer jf.mu.Unlock()
+
+	file, err := os.OpenFile(jf.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"fmt"
+	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
@@ -15,6 +16,11 @@ const (
 	PrefixPathType PathType = "Prefix"
 )
 
+var (
+	schemeRegex = regexp.MustCompile(`http[s]?\:\/\/`)
+	portRegex   = regexp.MustCompile(`\:\d+`)
+)
+
 type PathType string
 
 type ProxyPath struct {
@@ -24,131 +30,164 @@ type ProxyPath struct {
 	TLS             bool     `yaml:"tls"`
 	EnableReplay    bool     `yaml:"enableReplay"`
 	EnableRateLimit bool     `yaml:"enableRateLimit"`
+
+	// Explicit destination for forwarded requests.
+	// 	- If this setting is absent, the rule's upstream (if any) is used.
+	// 	- If neither is set, requests are forwarded to the incoming hostname.
+	Upstream *ProxyUpstream `yaml:"upstream"`
+
+	// Backends sharing this path's traffic (takes precedence over `upstream`).
+	Upstreams []ProxyUpstream `yaml:"upstreams"`
+
+	// Controls how a backend is chosen among `upstreams`.
+	LoadBalancer LoadBalancerSettings `yaml:"loadBalancer"`
+
+	// Active health checks of the path's upstreams (disabled if absent).
+	HealthCheck *HealthCheckSettings `yaml:"healthCheck"`
+
+	// Ejects upstreams that keep failing (disabled if absent).
+	CircuitBreaker *CircuitBreakerSettings `yaml:"circuitBreaker"`
+
+	// Retries failed upstream requests (a single attempt is made if absent).
+	Retry *RetryPolicy `yaml:"retry"`
+
+	// Overrides the server's upstream timeouts.
+	Timeouts *TimeoutSettings `yaml:"timeouts"`
+
+	// Drop the query string of forwarded requests.
+	StripQuery bool `yaml:"stripQuery"`
+
+	// Changes applied (in order) to the query string of forwarded requests.
+	QueryRules []QueryRule `yaml:"query"`
+
+	// Changes applied to the path of forwarded requests.
+	Rewrite *PathRewriteRules `yaml:"rewrite"`
+
+	// Changes applied (after the rule's) to the headers of forwarded requests.
+	RequestHeaders []HeaderRule `yaml:"requestHeaders"`
+
+	// Changes applied (after the rule's) to the headers of the responses sent back.
+	ResponseHeaders []HeaderRule `yaml:"responseHeaders"`
+
+	// Token bucket applied when `enableRateLimit` is set (defaults apply if absent).
+	RateLimit *RateLimitSettings `yaml:"rateLimit"`
+
+	// Caches GET and HEAD responses (disabled if absent).
+	Cache *CacheSettings `yaml:"cache"`
+
+	// Collapses identical concurrent GET and HEAD requests into one upstream fetch (disabled if absent).
+	Coalescing *CoalescingSettings `yaml:"coalescing"`
+
+	// Replays a share of the path's requests when `enableReplay` is set (every request if absent).
+	ReplaySampling *ReplaySampling `yaml:"replaySampling"`
+
+	pool      *UpstreamPool
+	client    *http.Client
+	limiter   *RateLimiter
+	cache     *Cache
+	coalescer *Coalescer
 }
 
-func (p *ProxyPath) DownstreamURL(requestHost, requestPath string) (downstreamURL string) {
-	// Update URL scheme based on TLS parameter
-	if schemeRegex := regexp.MustCompile(`http[s]?\:\/\/`); schemeRegex.Match([]byte(requestHost)) {
-		requestHost = schemeRegex.ReplaceAllStringFunc(requestHost, func(s string) string {
-			if p.TLS {
-				return "https://"
-			}
-
-			return "http://"
-		})
-	} else {
-		if p.TLS {
-			requestHost = "https://" + requestHost
-		} else {
-			requestHost = "http://" + requestHost
-		}
+// RetryPolicy - Retry policy of the path with defaults applied.
+func (p *ProxyPath) RetryPolicy() RetryPolicy {
+	if p.Retry == nil {
+		return RetryPolicy{Attempts: 1}
 	}
 
-	// Use `path.Port` in URL host (if PortNumber parameter is set)
-	if portRegex := regexp.MustCompile(`:\d+`); portRegex.Match([]byte(requestHost)) {
-		requestHost = portRegex.ReplaceAllStringFunc(requestHost, func(s string) string {
-			if p.PortNumber > 0 {
-				return fmt.Sprintf(":%d", p.PortNumber)
-			}
-
-			return ""
-		})
-	} else {
-		if p.PortNumber > 0 {
-			requestHost += fmt.Sprintf(":%d", p.PortNumber)
-		}
+	return p.Retry.withDefaults()
+}
+
+// ForwardedQuery - Query string sent upstream for the incoming raw query.
+//
+// The original query is forwarded untouched unless the path declares query rules.
+func (p *ProxyPath) ForwardedQuery(rawQuery string) string {
+	if p.StripQuery {
+		return ""
 	}
 
-	switch p.PathType {
-	case PrefixPathType:
-		if !strings.HasPrefix(requestPath, p.Path) {
-			logger.Logger.
-				WithFields(logrus.Fields{"host": requestHost, "path": requestPath}).
-				Warn("Mismatched route")
+	if len(p.QueryRules) == 0 {
+		return rawQuery
+	}
 
-			return
-		}
+	original, _ := url.ParseQuery(rawQuery)
+	query, _ := url.ParseQuery(rawQuery)
 
-		// http[s?]://example.com[:port]?/path.*
-		downstreamURL = fmt.Sprintf(`%s%s`, requestHost, requestPath)
-		return
-	case ExactPathType:
-		if requestPath != p.Path {
-			logger.Logger.
-				WithFields(logrus.Fields{"host": requestHost, "path": requestPath}).
-				Warn("Mismatched route")
+	for _, rule := range p.QueryRules {
+		rule.Apply(query, original)
+	}
 
-			return
-		}
+	return query.Encode()
+}
 
-		// http[s]?://example.com[:port]?/path
-		downstreamURL = fmt.Sprintf(`%s%s`, requestHost, requestPath)
-		return
+// DownstreamURL - Forwarded request URL as a string (empty if the request path does not match).
+func (p *ProxyPath) DownstreamURL(requestHost, requestPath string) (downstreamURL string) {
+	if requestURL := p.RequestURL(requestHost, requestPath); requestURL != nil {
+		downstreamURL = requestURL.String()
 	}
 
-	logger.Logger.
-		WithFields(logrus.Fields{"host": requestHost, "path": requestPath}).
-		Warn("Mismatched route")
-
 	return
 }
 
+// RequestURL - Forwarded request URL (nil if the request path does not match).
 func (p *ProxyPath) RequestURL(requestHost, requestPath string) (requestURL *url.URL) {
-	if portRegex := regexp.MustCompile(`\:\d+`); portRegex.Match([]byte(requestHost)) {
-		// Use `path.Port` if it is present.
-		requestHost = portRegex.ReplaceAllStringFunc(requestHost, func(s string) string {
-			if p.PortNumber > 0 {
-				return fmt.Sprintf(`:%d`, p.PortNumber)
-			} else {
-				return ""
-			}
-		})
-	} else {
-		if p.PortNumber > 0 {
-			requestHost = requestHost + fmt.Sprintf(":%d", p.PortNumber)
-		}
+	if !p.Matches(requestPath) {
+		logger.Logger.
+			WithFields(logrus.Fields{"host": requestHost, "path": requestPath}).
+			Warn("Mismatched route")
+
+		return
 	}
 
-	var scheme string = func() string {
-		if p.TLS {
-			return "https://"
-		} else {
-			return "http://"
-		}
-	}()
+	forwardedPath := p.ForwardedPath(requestPath)
 
-	// Overwrite host scheme based on TLS configuration.
-	schemeRegex := regexp.MustCompile(`http[s]?\:\/\/`)
-	requestHost = schemeRegex.ReplaceAllLiteralString(requestHost, "")
+	if p.Upstream != nil {
+		requestURL, _ = p.Upstream.URL(forwardedPath, p.TLS)
+		return
+	}
 
+	// http[s]?://example.com[:port]?/path
+	requestURL, _ = url.Parse(p.scheme() + "://" + p.host(requestHost) + forwardedPath)
+	return
+}
+
+// Matches - Checks if the request path is handled by this path.
+func (p *ProxyPath) Matches(requestPath string) bool {
 	switch p.PathType {
 	case PrefixPathType:
-		if !strings.HasPrefix(requestPath, p.Path) {
-			logger.Logger.
-				WithFields(logrus.Fields{"host": requestHost, "path": requestPath}).
-				Warn("Mismatched route")
+		return strings.HasPrefix(requestPath, p.Path)
+	case ExactPathType:
+		return requestPath == p.Path
+	}
 
-			return
-		}
+	return false
+}
 
-		requestURL, _ = url.Parse(scheme + requestHost + requestPath)
-		return
-	case ExactPathType:
-		if requestPath != p.Path {
-			logger.Logger.
-				WithFields(logrus.Fields{"host": requestHost, "path": requestPath}).
-				Warn("Mismatched route")
+// ForwardedPath - Request path sent upstream after applying the path's rewrite rules.
+func (p *ProxyPath) ForwardedPath(requestPath string) string {
+	if p.Rewrite == nil {
+		return requestPath
+	}
 
-			return
-		}
+	return p.Rewrite.Apply(requestPath)
+}
 
-		requestURL, _ = url.Parse(scheme + requestHost + requestPath)
-		return
+// scheme - Forwarded request scheme based on the TLS parameter.
+func (p *ProxyPath) scheme() string {
+	if p.TLS {
+		return "https"
 	}
 
-	logger.Logger.
-		WithFields(logrus.Fields{"host": requestHost, "path": requestPath}).
-		Warn("Mismatched route")
+	return "http"
+}
 
-	return
+// host - Request host without its scheme, using `PortNumber` as the port (if set).
+func (p *ProxyPath) host(requestHost string) string {
+	requestHost = schemeRegex.ReplaceAllLiteralString(requestHost, "")
+	requestHost = portRegex.ReplaceAllLiteralString(requestHost, "")
+
+	if p.PortNumber > 0 {
+		requestHost += fmt.Sprintf(":%d", p.PortNumber)
+	}
+
+	return requestHost
 }
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"net/http"
 	"net/url"
 	"testing"
 )
@@ -116,6 +117,32 @@ func Test_DownstreamURL(t *testing.T) {
 			},
 			expectedURL: "https://example.com/posts",
 		},
+		{
+			name: "example.com/people - with upstream",
+			args: args{
+				proxyPath: ProxyPath{
+					Path:     "/people",
+					PathType: ExactPathType,
+					Upstream: &ProxyUpstream{Host: "people-svc.internal", Port: 4000},
+				},
+				requestHost: "example.com",
+				requestPath: "/people",
+			},
+			expectedURL: "http://people-svc.internal:4000/people",
+		},
+		{
+			name: "example.com/people - with upstream scheme and base path",
+			args: args{
+				proxyPath: ProxyPath{
+					Path:     "/people",
+					PathType: ExactPathType,
+					Upstream: &ProxyUpstream{Scheme: "https", Host: "people-svc.internal", BasePath: "/v1/"},
+				},
+				requestHost: "example.com:5000",
+				requestPath: "/people",
+			},
+			expectedURL: "https://people-svc.internal/v1/people",
+		},
 	}
 
 	prefixMatches := []struct {
@@ -234,6 +261,170 @@ func Test_DownstreamURL(t *testing.T) {
 	}
 }
 
+func Test_ForwardedPath(t *testing.T) {
+	tests := []struct {
+		name         string
+		rewrite      *PathRewriteRules
+		requestPath  string
+		expectedPath string
+	}{
+		{
+			name:         "no rewrite",
+			requestPath:  "/api/people",
+			expectedPath: "/api/people",
+		},
+		{
+			name:         "strip prefix",
+			rewrite:      &PathRewriteRules{StripPrefix: "/api"},
+			requestPath:  "/api/people",
+			expectedPath: "/people",
+		},
+		{
+			name:         "strip whole path",
+			rewrite:      &PathRewriteRules{StripPrefix: "/api"},
+			requestPath:  "/api",
+			expectedPath: "/",
+		},
+		{
+			name:         "strip missing prefix",
+			rewrite:      &PathRewriteRules{StripPrefix: "/api"},
+			requestPath:  "/people",
+			expectedPath: "/people",
+		},
+		{
+			name:         "add prefix",
+			rewrite:      &PathRewriteRules{AddPrefix: "/internal/"},
+			requestPath:  "/people",
+			expectedPath: "/internal/people",
+		},
+		{
+			name:         "regex capture groups",
+			rewrite:      &PathRewriteRules{Regex: `^/api/v1/(.*)`, Replacement: "/$1"},
+			requestPath:  "/api/v1/people/1",
+			expectedPath: "/people/1",
+		},
+		{
+			name:         "regex reordering",
+			rewrite:      &PathRewriteRules{Regex: `^/users/(\d+)/posts/(\d+)$`, Replacement: "/posts/${2}/authors/${1}"},
+			requestPath:  "/users/7/posts/42",
+			expectedPath: "/posts/42/authors/7",
+		},
+		{
+			name:         "regex mismatch",
+			rewrite:      &PathRewriteRules{Regex: `^/api/v2/(.*)`, Replacement: "/$1"},
+			requestPath:  "/api/v1/people",
+			expectedPath: "/api/v1/people",
+		},
+		{
+			name:         "invalid regex",
+			rewrite:      &PathRewriteRules{Regex: `^/api/(`, Replacement: "/"},
+			requestPath:  "/api/people",
+			expectedPath: "/api/people",
+		},
+		{
+			name:         "strip, regex and add",
+			rewrite:      &PathRewriteRules{StripPrefix: "/public", Regex: `^/v1`, Replacement: "", AddPrefix: "/svc"},
+			requestPath:  "/public/v1/people",
+			expectedPath: "/svc/people",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyPath := ProxyPath{Path: "/", PathType: PrefixPathType, Rewrite: tt.rewrite}
+
+			if path := proxyPath.ForwardedPath(tt.requestPath); path != tt.expectedPath {
+				t.Errorf(`expected %s but got %s`, tt.expectedPath, path)
+			}
+		})
+	}
+}
+
+func Test_ForwardedQuery(t *testing.T) {
+	tests := []struct {
+		name          string
+		proxyPath     ProxyPath
+		rawQuery      string
+		expectedQuery string
+	}{
+		{
+			name:          "preserved by default",
+			proxyPath:     ProxyPath{},
+			rawQuery:      "page=2&sort=desc&sort=name",
+			expectedQuery: "page=2&sort=desc&sort=name",
+		},
+		{
+			name:          "stripped",
+			proxyPath:     ProxyPath{StripQuery: true},
+			rawQuery:      "page=2",
+			expectedQuery: "",
+		},
+		{
+			name:          "add",
+			proxyPath:     ProxyPath{QueryRules: []QueryRule{{Action: AddQueryAction, Name: "sort", Value: "name"}}},
+			rawQuery:      "sort=desc",
+			expectedQuery: "sort=desc&sort=name",
+		},
+		{
+			name:          "set",
+			proxyPath:     ProxyPath{QueryRules: []QueryRule{{Action: SetQueryAction, Name: "limit", Value: "50"}}},
+			rawQuery:      "limit=10&limit=20&page=1",
+			expectedQuery: "limit=50&page=1",
+		},
+		{
+			name:          "remove",
+			proxyPath:     ProxyPath{QueryRules: []QueryRule{{Action: RemoveQueryAction, Name: "token"}}},
+			rawQuery:      "page=1&token=secret",
+			expectedQuery: "page=1",
+		},
+		{
+			name:          "rename",
+			proxyPath:     ProxyPath{QueryRules: []QueryRule{{Action: RenameQueryAction, Name: "p", To: "page"}}},
+			rawQuery:      "p=3",
+			expectedQuery: "page=3",
+		},
+		{
+			name:          "rename missing parameter",
+			proxyPath:     ProxyPath{QueryRules: []QueryRule{{Action: RenameQueryAction, Name: "p", To: "page"}}},
+			rawQuery:      "q=go",
+			expectedQuery: "q=go",
+		},
+		{
+			name: "original values as variables",
+			proxyPath: ProxyPath{QueryRules: []QueryRule{
+				{Action: RemoveQueryAction, Name: "p"},
+				{Action: SetQueryAction, Name: "offset", Value: "${query.p}0"},
+				{Action: SetQueryAction, Name: "missing", Value: "${query.unknown}"},
+			}},
+			rawQuery:      "p=3",
+			expectedQuery: "missing=&offset=30",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if query := tt.proxyPath.ForwardedQuery(tt.rawQuery); query != tt.expectedQuery {
+				t.Errorf(`expected %s but got %s`, tt.expectedQuery, query)
+			}
+		})
+	}
+}
+
+func Test_ForwardedQuery_Upstream(t *testing.T) {
+	upstream := newTestUpstream(t, "", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RawQuery))
+	})
+
+	xy := newTestServer(t, ProxyPath{
+		Upstreams:  []ProxyUpstream{upstream},
+		QueryRules: []QueryRule{{Action: RenameQueryAction, Name: "p", To: "page"}},
+	})
+
+	if _, query := send(t, xy, newRequest(http.MethodGet, "/people?p=2&q=john")); query != "page=2&q=john" {
+		t.Errorf(`expected page=2&q=john but got %s`, query)
+	}
+}
+
 func Test_RequestURL(t *testing.T) {
 	type args struct {
 		proxyPath   ProxyPath
@@ -381,6 +572,42 @@ func Test_RequestURL(t *testing.T) {
 				Path:   "posts",
 			},
 		},
+		{
+			name: "example.com/api/people - with upstream and path rewrite",
+			args: args{
+				proxyPath: ProxyPath{
+					Path:     "/api/people",
+					PathType: ExactPathType,
+					Upstream: &ProxyUpstream{Host: "people-svc.internal", BasePath: "/v1"},
+					Rewrite:  &PathRewriteRules{StripPrefix: "/api"},
+				},
+				requestHost: "example.com",
+				requestPath: "/api/people",
+			},
+			expectedURL: &url.URL{
+				Scheme: "http",
+				Host:   "people-svc.internal",
+				Path:   "/v1/people",
+			},
+		},
+		{
+			name: "example.com/people - with upstream",
+			args: args{
+				proxyPath: ProxyPath{
+					Path:     "/people",
+					PathType: ExactPathType,
+					TLS:      true,
+					Upstream: &ProxyUpstream{Host: "people-svc.internal", Port: 4000, BasePath: "/v1"},
+				},
+				requestHost: "example.com",
+				requestPath: "/people",
+			},
+			expectedURL: &url.URL{
+				Scheme: "https",
+				Host:   "people-svc.internal:4000",
+				Path:   "/v1/people",
+			},
+		},
 	}
 
 	prefixMatches := []struct {
@@ -1,7 +1,11 @@
 package proxy
 
 import (
+	"fmt"
 	"sync"
+	"time"
+
+	"github.com/cleopatrio/proxy/helpers"
 )
 
 const (
@@ -14,12 +18,20 @@ const (
 	PreserveMethodStrategy MethodRewriteStrategy = "preserve"
 	RewriteMethodStrategy  MethodRewriteStrategy = "rewrite"
 
+	// Replayed request
+	EnvelopeReplayMode ReplayMode = "envelope"
+	MirrorReplayMode   ReplayMode = "mirror"
+
 	// Server defaults
 	DefaultHTTPPort      int    = 8080
 	EnableRateLimiting   bool   = false
 	EnableStackTrace     bool   = false
 	EnableReplayRequests bool   = false
 	HTTPRequestIdHeader  string = "X-Request-Id"
+
+	// Upstream timeout defaults
+	DefaultConnectTimeout      time.Duration = 5 * time.Second
+	DefaultTLSHandshakeTimeout time.Duration = 5 * time.Second
 )
 
 var (
@@ -33,6 +45,9 @@ type PathRewriteStrategy string
 // MethodRewriteStrategy - Controls whether the original request method should be preserved
 type MethodRewriteStrategy string
 
+// ReplayMode - Controls how the original request is sent to the replay target
+type ReplayMode string
+
 // Proxifyle - Proxy configuration.
 type Proxyfile struct {
 	Annotations struct {
@@ -68,41 +83,84 @@ type ProxyServer struct {
 	Port int `yaml:"port"`
 	// Server replay settings.
 	Replay ProxyReplay `yaml:"replay"`
+	// Server-wide limit on upstream retries.
+	RetryBudget RetryBudgetSettings `yaml:"retryBudget"`
+	// Default upstream timeouts (each path can override them).
+	Timeouts TimeoutSettings `yaml:"timeouts"`
+	// Default connection pooling settings (each upstream can override them).
+	Transport TransportSettings `yaml:"transport"`
+	// Forwarding headers sent to upstreams.
+	ForwardedHeaders ForwardedHeadersSettings `yaml:"forwardedHeaders"`
+	// Where rate limit buckets are kept (shared by replicas when using Redis).
+	RateLimitStore RateLimitStoreSettings `yaml:"rateLimitStore"`
+	// Admin API (disabled if absent).
+	Admin AdminSettings `yaml:"admin"`
 }
 
 // ProxyEndpointRule - Endpoint route configuration.
 type ProxyEndpointRule struct {
 	Host  string      `yaml:"host"`
 	Paths []ProxyPath `yaml:"paths"`
+
+	// Default destination for every path in this rule.
+	Upstream *ProxyUpstream `yaml:"upstream"`
+
+	// Changes applied to the headers of forwarded requests (for every path in this rule).
+	RequestHeaders []HeaderRule `yaml:"requestHeaders"`
+
+	// Changes applied to the headers of the responses sent back (for every path in this rule).
+	ResponseHeaders []HeaderRule `yaml:"responseHeaders"`
 }
 
 // ProxyReplay - Controls where and how HTTP requests are replayed
 type ProxyReplay struct {
-	// Replayed requests will be sent using this protocol [http/https]
-	Scheme string `yaml:"scheme"`
+	// Single replay target (used when `targets` is empty).
+	ReplayTarget `yaml:",inline"`
 
-	// Replayed requests will be sent to this host.
-	Host string `yaml:"host"`
+	// Every request is sent to each target whose filter it matches.
+	Targets []ReplayTarget `yaml:"targets"`
 
-	// Replayed requests will be sent to this port.
-	Port int `yaml:"port"`
+	// Overrides the server's connection pooling settings.
+	Transport *TransportSettings `yaml:"transport"`
 
-	// Replayed requests will not include these headers.
-	SuppressedHeaders []struct{ Name string } `yaml:"suppressedHeaders"`
+	// Bounds the workers and queue used to replay requests.
+	Dispatcher ReplayDispatcherSettings `yaml:"dispatcher"`
 
-	MethodRewriteSettings struct {
-		Strategy MethodRewriteStrategy
-		Method   string
-	} `yaml:"methodRewriteSettings"`
+	// Retries failed replays (a single attempt is made if absent).
+	// 	- Replays are copies sent to a shadow target, so they are retried whatever their method.
+	// 	- Any 5xx response fails the replay (and dead-letters it), but only `retryableStatuses` are retried.
+	Retry *RetryPolicy `yaml:"retry"`
 
-	PathRewriteSettings struct {
-		Strategy PathRewriteStrategy
-		Path     string
-	} `yaml:"pathRewriteSettings"`
+	// Replays failing on every attempt are appended to this JSONL file (see the `redrive` command).
+	DeadLetterFile string `yaml:"deadLetterFile"`
 }
 
 func (pf *Proxyfile) ReplayConfig() ProxyReplay { return pf.Spec.Server.Replay }
 
+// ReplayTargets - Destinations of replayed requests (named after their position unless set).
+func (pr ProxyReplay) ReplayTargets() []ReplayTarget {
+	if len(pr.Targets) == 0 {
+		return []ReplayTarget{pr.ReplayTarget.withDefaults(DefaultReplayTargetName)}
+	}
+
+	return helpers.Map(pr.Targets, func(i int, target ReplayTarget) ReplayTarget {
+		return target.withDefaults(fmt.Sprintf("target-%d", i+1))
+	})
+}
+
+// RetryPolicy - How failed replays are retried (failures are still classified if retries are disabled).
+func (pr ProxyReplay) RetryPolicy() RetryPolicy {
+	policy := RetryPolicy{Attempts: 1}
+	if pr.Retry != nil {
+		policy = *pr.Retry
+	}
+
+	// The idempotency of live traffic does not matter to copies.
+	policy.RetryNonIdempotent = true
+
+	return policy.withDefaults()
+}
+
 func (pf *Proxyfile) ServerConfig() ProxyServer { return pf.Spec.Server }
 
 func (pf *Proxyfile) ServerPort() int { return pf.Spec.Server.Port }
@@ -113,6 +171,8 @@ func (pf *Proxyfile) Rules() []ProxyEndpointRule { return pf.Spec.Rules }
 
 func (pf *Proxyfile) ReplayEnabled() bool { return pf.Annotations.ReplayRequestsEnabled }
 
+func (pf *Proxyfile) RateLimitEnabled() bool { return pf.Annotations.RateLimitingEnabled }
+
 func init() {
 	once.Do(func() {
 		PxFile.Spec.Server.Port = DefaultHTTPPort
@@ -124,5 +184,12 @@ func init() {
 		PxFile.Spec.Server.Replay.MethodRewriteSettings.Strategy = PreserveMethodStrategy
 		PxFile.Spec.Server.Replay.PathRewriteSettings.Strategy = PreservePathStrategy
 		PxFile.Spec.Server.Replay.Scheme = "http"
+		PxFile.Spec.Server.Replay.Mode = EnvelopeReplayMode
+		PxFile.Spec.Server.Replay.DeadLetterFile = DefaultDeadLetterFile
+
+		PxFile.Spec.Server.Timeouts = TimeoutSettings{
+			Connect:      DefaultConnectTimeout,
+			TLSHandshake: DefaultTLSHandshakeTimeout,
+		}
 	})
 }
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+)
+
+const (
+	// Query rule actions
+	AddQueryAction    QueryAction = "add"
+	SetQueryAction    QueryAction = "set"
+	RemoveQueryAction QueryAction = "remove"
+	RenameQueryAction QueryAction = "rename"
+)
+
+// QueryAction - Controls how a query rule changes the forwarded query string.
+type QueryAction string
+
+// QueryRule - Changes a query parameter of forwarded requests.
+//
+// Values may reference the original query parameters, e.g. `${query.page}`.
+type QueryRule struct {
+	Action QueryAction `yaml:"action" example:"set"`
+
+	// Query parameter affected by the rule.
+	Name string `yaml:"name" example:"page"`
+
+	// Value used by the `add` and `set` actions.
+	Value string `yaml:"value" example:"${query.p}"`
+
+	// New parameter name used by the `rename` action.
+	To string `yaml:"to" example:"offset"`
+}
+
+// Apply - Applies the rule to `query`, resolving variables against `original`.
+func (qr QueryRule) Apply(query, original url.Values) {
+	switch qr.Action {
+	case AddQueryAction:
+		query.Add(qr.Name, expandQueryVariables(qr.Value, original))
+	case SetQueryAction:
+		query.Set(qr.Name, expandQueryVariables(qr.Value, original))
+	case RemoveQueryAction:
+		query.Del(qr.Name)
+	case RenameQueryAction:
+		if values, ok := query[qr.Name]; ok && qr.To != "" {
+			query.Del(qr.Name)
+			query[qr.To] = append(query[qr.To], values...)
+		}
+	}
+}
+
+func expandQueryVariables(value string, original url.Values) string {
+	return expandTemplate(value, func(name string) string {
+		if !strings.HasPrefix(name, "query.") {
+			return ""
+		}
+
+		return original.Get(strings.TrimPrefix(name, "query."))
+	})
+}
@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cleopatrio/proxy/logger"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Rate limit key sources
+	ClientIPRateLimitKey RateLimitKeySource = "ip"
+	HeaderRateLimitKey   RateLimitKeySource = "header"
+	APIKeyRateLimitKey   RateLimitKeySource = "api-key"
+	JWTClaimRateLimitKey RateLimitKeySource = "jwt-claim"
+
+	// Rate limit defaults
+	DefaultRateLimitRate     int           = 100
+	DefaultRateLimitPeriod   time.Duration = time.Second
+	DefaultRateLimitAPIKey   string        = "X-Api-Key"
+	DefaultRateLimitJWTClaim string        = "sub"
+)
+
+// RateLimitKeySource - Request attribute that identifies who is being rate limited.
+type RateLimitKeySource string
+
+// RateLimitSettings - Token bucket configuration of a path.
+type RateLimitSettings struct {
+	// Number of requests allowed per `period`.
+	Rate int `yaml:"rate" example:"10"`
+
+	// Duration in which `rate` requests are allowed.
+	Period time.Duration `yaml:"period" example:"1s"`
+
+	// Maximum number of requests allowed at once (defaults to `rate`).
+	Burst int `yaml:"burst" example:"20"`
+
+	// Identifies clients [ip/header/api-key/jwt-claim] (defaults to the client IP).
+	Key RateLimitKeySource `yaml:"key" example:"header"`
+
+	// Header (header, api-key) or claim (jwt-claim) holding the key.
+	// Requests without it are limited by client IP.
+	KeyName string `yaml:"keyName" example:"X-Tenant-Id"`
+}
+
+func (rs RateLimitSettings) withDefaults() RateLimitSettings {
+	if rs.Rate <= 0 {
+		rs.Rate = DefaultRateLimitRate
+	}
+
+	if rs.Period <= 0 {
+		rs.Period = DefaultRateLimitPeriod
+	}
+
+	// Tokens are refilled one nanosecond apart at most (a shorter interval would round down to 0).
+	if rs.Period < time.Duration(rs.Rate) {
+		rs.Period = time.Duration(rs.Rate)
+	}
+
+	if rs.Burst <= 0 {
+		rs.Burst = rs.Rate
+	}
+
+	if rs.KeyName == "" {
+		switch rs.Key {
+		case APIKeyRateLimitKey:
+			rs.KeyName = DefaultRateLimitAPIKey
+		case JWTClaimRateLimitKey:
+			rs.KeyName = DefaultRateLimitJWTClaim
+		}
+	}
+
+	return rs
+}
+
+// EmissionInterval - Time it takes for a single token to be refilled.
+func (rs RateLimitSettings) EmissionInterval() time.Duration {
+	return rs.Period / time.Duration(rs.Rate)
+}
+
+// RequestKey - Identifies the client of a request.
+//
+// JWT claims are read without verifying the token signature, which is left to upstreams.
+func (rs RateLimitSettings) RequestKey(c *fiber.Ctx, forwarding *Forwarding) string {
+	var key string
+
+	switch rs.Key {
+	case HeaderRateLimitKey, APIKeyRateLimitKey:
+		key = c.Get(rs.KeyName)
+	case JWTClaimRateLimitKey:
+		key = jwtClaim(c.Get(fiber.HeaderAuthorization), rs.KeyName)
+	}
+
+	if key == "" {
+		return "ip:" + forwarding.ClientIP(c)
+	}
+
+	return string(rs.Key) + ":" + key
+}
+
+// RateLimitDecision - Outcome of a rate limit check.
+type RateLimitDecision struct {
+	Allowed bool
+
+	// Maximum number of requests allowed at once.
+	Limit int
+
+	// Requests left before being limited.
+	Remaining int
+
+	// Time until the bucket is full again.
+	Reset time.Duration
+
+	// Time until the next request is allowed (only when not allowed).
+	RetryAfter time.Duration
+}
+
+// Apply - Sets the `RateLimit-*` (and `Retry-After`) response headers.
+func (d RateLimitDecision) Apply(c *fiber.Ctx) {
+	c.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	c.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	c.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+
+	if !d.Allowed {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(ceilSeconds(d.RetryAfter)))
+	}
+}
+
+// gcra - Token bucket expressed as the generic cell rate algorithm.
+//
+// Only the theoretical arrival time (`tat`) of the next request is stored per key.
+func gcra(settings RateLimitSettings, tat, now time.Time) (RateLimitDecision, time.Time) {
+	interval := settings.EmissionInterval()
+	capacity := time.Duration(settings.Burst) * interval
+
+	if tat.Before(now) {
+		tat = now
+	}
+
+	next := tat.Add(interval)
+	allowAt := next.Add(-capacity)
+
+	decision := RateLimitDecision{Limit: settings.Burst}
+
+	if now.Before(allowAt) {
+		decision.RetryAfter = allowAt.Sub(now)
+		decision.Reset = tat.Sub(now)
+		return decision, tat
+	}
+
+	decision.Allowed = true
+	decision.Remaining = int(now.Sub(allowAt) / interval)
+	decision.Reset = next.Sub(now)
+
+	return decision, next
+}
+
+// RateLimiter - Token buckets of a path.
+type RateLimiter struct {
+	Settings   RateLimitSettings
+	Forwarding *Forwarding
+	Store      RateLimitStore
+
+	// Prepended to client keys so that paths sharing a store have their own buckets.
+	Route string
+
+	// Applied when the store is unreachable.
+	FailurePolicy RateLimitFailurePolicy
+}
+
+// NewRateLimiter - Creates the rate limiter of a path.
+func NewRateLimiter(settings RateLimitSettings, route string, store RateLimitStore, forwarding *Forwarding) *RateLimiter {
+	return &RateLimiter{
+		Settings:      settings.withDefaults(),
+		Forwarding:    forwarding,
+		Store:         store,
+		Route:         route,
+		FailurePolicy: FailOpenPolicy,
+	}
+}
+
+// Allow - Takes a token from the bucket of `key`.
+func (rl *RateLimiter) Allow(ctx context.Context, key string) (RateLimitDecision, error) {
+	return rl.Store.Take(ctx, rl.Route+"|"+key, rl.Settings)
+}
+
+// Handle - Rate limits the request, answering `429` once its client runs out of tokens.
+//
+// Returns true if the request was rejected.
+func (rl *RateLimiter) Handle(c *fiber.Ctx) (bool, error) {
+	decision, err := rl.Allow(c.UserContext(), rl.Settings.RequestKey(c, rl.Forwarding))
+	if err != nil {
+		logger.Logger.WithFields(logrus.Fields{
+			"request.id":     c.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader),
+			"route":          rl.Route,
+			"path":           c.Path(),
+			"failure.policy": rl.FailurePolicy,
+			"error":          err,
+		}).Error("Rate limit store is unreachable 🧯")
+
+		if rl.FailurePolicy == FailClosedPolicy {
+			return true, c.SendStatus(fiber.StatusServiceUnavailable)
+		}
+
+		return false, nil
+	}
+
+	decision.Apply(c)
+
+	if decision.Allowed {
+		return false, nil
+	}
+
+	logger.Logger.WithFields(logrus.Fields{
+		"request.id": c.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader),
+		"route":      rl.Route,
+		"path":       c.Path(),
+	}).Warn("Rate limit exceeded 🚦")
+
+	return true, c.SendStatus(fiber.StatusTooManyRequests)
+}
+
+// jwtClaim - Reads a claim of a bearer token without verifying it.
+func jwtClaim(authorization, claim string) string {
+	token := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return ""
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segments[1], "="))
+	if err != nil {
+		return ""
+	}
+
+	claims := map[string]any{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+
+	switch value := claims[claim].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Rate limit stores
+	MemoryRateLimitStoreType RateLimitStoreType = "memory"
+	RedisRateLimitStoreType  RateLimitStoreType = "redis"
+
+	// Rate limit store failure policies
+	FailOpenPolicy   RateLimitFailurePolicy = "open"
+	FailClosedPolicy RateLimitFailurePolicy = "closed"
+
+	// Rate limit store defaults
+	DefaultRateLimitKeyPrefix string = "proxy:ratelimit:"
+
+	// Stale buckets are swept after this many decisions.
+	rateLimitSweepInterval = 1024
+)
+
+// RateLimitStoreType - Backend keeping the state of rate limiters.
+type RateLimitStoreType string
+
+// RateLimitFailurePolicy - Controls what happens to requests while the store is unreachable.
+type RateLimitFailurePolicy string
+
+// RateLimitStoreSettings - Where rate limit buckets are kept.
+//
+// Replicas sharing a Redis store enforce a single limit instead of one each.
+type RateLimitStoreSettings struct {
+	// Store type [memory/redis] (defaults to memory).
+	Type RateLimitStoreType `yaml:"type" example:"redis"`
+
+	// Redis server address.
+	Address string `yaml:"address" example:"localhost:6379"`
+
+	// Redis ACL user and password.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// Redis logical database.
+	Database int `yaml:"database" example:"0"`
+
+	// Upper bound of every round trip to the store.
+	Timeout time.Duration `yaml:"timeout" example:"100ms"`
+
+	// Maximum number of idle connections kept open.
+	PoolSize int `yaml:"poolSize" example:"8"`
+
+	// Prepended to every bucket key.
+	KeyPrefix string `yaml:"keyPrefix" example:"proxy:ratelimit:"`
+
+	// Let requests through [open] or reject them [closed] while the store is unreachable.
+	FailurePolicy RateLimitFailurePolicy `yaml:"failurePolicy" example:"open"`
+}
+
+func (ss RateLimitStoreSettings) withDefaults() RateLimitStoreSettings {
+	if ss.Type == "" {
+		ss.Type = MemoryRateLimitStoreType
+	}
+
+	if ss.KeyPrefix == "" {
+		ss.KeyPrefix = DefaultRateLimitKeyPrefix
+	}
+
+	if ss.FailurePolicy == "" {
+		ss.FailurePolicy = FailOpenPolicy
+	}
+
+	return ss
+}
+
+// RateLimitStore - Atomically takes tokens from shared buckets.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, settings RateLimitSettings) (RateLimitDecision, error)
+}
+
+// NewRateLimitStore - Creates the store described by the settings.
+func NewRateLimitStore(settings RateLimitStoreSettings) RateLimitStore {
+	settings = settings.withDefaults()
+
+	if settings.Type == RedisRateLimitStoreType {
+		return NewRedisRateLimitStore(NewRedisClient(settings), settings.KeyPrefix)
+	}
+
+	return NewMemoryRateLimitStore()
+}
+
+// MemoryRateLimitStore - Buckets local to this process.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	buckets   map[string]time.Time
+	decisions int
+}
+
+// NewMemoryRateLimitStore - Creates an empty in-memory store.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{now: time.Now, buckets: map[string]time.Time{}}
+}
+
+func (ms *MemoryRateLimitStore) Take(_ context.Context, key string, settings RateLimitSettings) (RateLimitDecision, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := ms.now()
+
+	decision, tat := gcra(settings, ms.buckets[key], now)
+	ms.buckets[key] = tat
+
+	if ms.decisions++; ms.decisions%rateLimitSweepInterval == 0 {
+		for key, tat := range ms.buckets {
+			if !tat.After(now) {
+				delete(ms.buckets, key)
+			}
+		}
+	}
+
+	return decision, nil
+}
+
+// gcraScript - Same algorithm as `gcra`, run atomically by Redis.
+//
+// The server clock is used so that replicas agree on time.
+// KEYS[1] is the bucket; ARGV holds the emission interval and the capacity (in microseconds).
+const gcraScript = `
+if redis.replicate_commands then redis.replicate_commands() end
+
+local interval = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+if tat < now then tat = now end
+
+local next = tat + interval
+local allow_at = next - capacity
+
+if now < allow_at then
+	return {0, 0, allow_at - now, tat - now}
+end
+
+redis.call('SET', KEYS[1], next, 'PX', math.ceil((next - now) / 1000))
+return {1, math.floor((now - allow_at) / interval), 0, next - now}
+`
+
+// RedisRateLimitStore - Buckets shared by every replica through Redis.
+type RedisRateLimitStore struct {
+	Client    *RedisClient
+	KeyPrefix string
+}
+
+// NewRedisRateLimitStore - Creates a store keeping buckets in Redis.
+func NewRedisRateLimitStore(client *RedisClient, keyPrefix string) *RedisRateLimitStore {
+	return &RedisRateLimitStore{Client: client, KeyPrefix: keyPrefix}
+}
+
+func (rs *RedisRateLimitStore) Take(ctx context.Context, key string, settings RateLimitSettings) (RateLimitDecision, error) {
+	interval := settings.EmissionInterval()
+	capacity := time.Duration(settings.Burst) * interval
+
+	reply, err := rs.Client.Eval(ctx, gcraScript,
+		[]string{rs.KeyPrefix + key},
+		strconv.FormatInt(interval.Microseconds(), 10),
+		strconv.FormatInt(capacity.Microseconds(), 10),
+	)
+	if err != nil {
+		return RateLimitDecision{}, err
+	}
+
+	values, ok := reply.([]any)
+	if !ok || len(values) != 4 {
+		return RateLimitDecision{}, fmt.Errorf("unexpected rate limit script reply %v", reply)
+	}
+
+	integers := make([]int64, len(values))
+	for i, value := range values {
+		if integers[i], ok = value.(int64); !ok {
+			return RateLimitDecision{}, fmt.Errorf("unexpected rate limit script reply %v", reply)
+		}
+	}
+
+	return RateLimitDecision{
+		Allowed:    integers[0] == 1,
+		Limit:      settings.Burst,
+		Remaining:  int(integers[1]),
+		RetryAfter: time.Duration(integers[2]) * time.Microsecond,
+		Reset:      time.Duration(integers[3]) * time.Microsecond,
+	}, nil
+}
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis - Stand-in Redis server running the GCRA script natively against a fake clock.
+type fakeRedis struct {
+	listener net.Listener
+	clock    *fakeClock
+
+	mu       sync.Mutex
+	data     map[string]int64
+	scripts  map[string]bool
+	commands []string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	fr := &fakeRedis{
+		listener: listener,
+		clock:    &fakeClock{current: time.Unix(0, 0)},
+		data:     map[string]int64{},
+		scripts:  map[string]bool{},
+	}
+
+	go fr.serve()
+	t.Cleanup(func() { listener.Close() })
+
+	return fr
+}
+
+func (fr *fakeRedis) Address() string { return fr.listener.Addr().String() }
+
+func (fr *fakeRedis) Commands() []string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	return append([]string{}, fr.commands...)
+}
+
+func (fr *fakeRedis) serve() {
+	for {
+		conn, err := fr.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			reader := bufio.NewReader(conn)
+			for {
+				request, err := readRedisReply(reader)
+				if err != nil {
+					return
+				}
+
+				args := []string{}
+				for _, arg := range request.([]any) {
+					args = append(args, arg.(string))
+				}
+
+				fmt.Fprint(conn, fr.handle(args))
+			}
+		}()
+	}
+}
+
+func (fr *fakeRedis) handle(args []string) string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	fr.commands = append(fr.commands, args[0])
+
+	switch args[0] {
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "EVAL":
+		digest := sha1.Sum([]byte(args[1]))
+		fr.scripts[hex.EncodeToString(digest[:])] = true
+	case "EVALSHA":
+		if !fr.scripts[args[1]] {
+			return "-NOSCRIPT No matching script\r\n"
+		}
+	default:
+		return "-ERR unknown command\r\n"
+	}
+
+	interval, _ := strconv.ParseInt(args[4], 10, 64)
+	capacity, _ := strconv.ParseInt(args[5], 10, 64)
+
+	// Mirrors `gcraScript`.
+	now := fr.clock.now().UnixMicro()
+
+	tat, ok := fr.data[args[3]]
+	if !ok || tat < now {
+		tat = now
+	}
+
+	next := tat + interval
+	allowAt := next - capacity
+
+	if now < allowAt {
+		return fmt.Sprintf("*4\r\n:0\r\n:0\r\n:%d\r\n:%d\r\n", allowAt-now, tat-now)
+	}
+
+	fr.data[args[3]] = next
+	return fmt.Sprintf("*4\r\n:1\r\n:%d\r\n:0\r\n:%d\r\n", (now-allowAt)/interval, next-now)
+}
+
+func Test_RedisRateLimitStore(t *testing.T) {
+	fr := newFakeRedis(t)
+	settings := RateLimitSettings{Rate: 2, Period: time.Second, Burst: 3}
+
+	// Two replicas sharing the same store.
+	replicas := []*RateLimiter{}
+	for i := 0; i < 2; i++ {
+		store := NewRateLimitStore(RateLimitStoreSettings{Type: RedisRateLimitStoreType, Address: fr.Address()})
+		replicas = append(replicas, NewRateLimiter(settings, "example.com/", store, nil))
+	}
+
+	for i, remaining := range []int{2, 1, 0} {
+		decision := take(t, replicas[i%2], "client")
+		if !decision.Allowed || decision.Remaining != remaining || decision.Limit != 3 {
+			t.Fatalf(`expected burst request with %d remaining but got %+v`, remaining, decision)
+		}
+	}
+
+	decision := take(t, replicas[1], "client")
+	if decision.Allowed || decision.RetryAfter != 500*time.Millisecond || decision.Reset != 1500*time.Millisecond {
+		t.Fatalf(`expected the limit to be shared by replicas but got %+v`, decision)
+	}
+
+	fr.clock.advance(500 * time.Millisecond)
+	if decision := take(t, replicas[0], "client"); !decision.Allowed {
+		t.Fatalf(`expected a refilled token but got %+v`, decision)
+	}
+
+	fr.mu.Lock()
+	if _, ok := fr.data["proxy:ratelimit:example.com/|client"]; !ok {
+		t.Errorf(`expected prefixed bucket key but got %v`, fr.data)
+	}
+	fr.mu.Unlock()
+
+	// The script is loaded once, then only its digest is sent.
+	expected := []string{"EVALSHA", "EVAL", "EVALSHA", "EVALSHA", "EVALSHA", "EVALSHA"}
+	if commands := fr.Commands(); fmt.Sprint(commands) != fmt.Sprint(expected) {
+		t.Errorf(`expected %v but got %v`, expected, commands)
+	}
+}
+
+func Test_RedisRateLimitStore_Connection(t *testing.T) {
+	fr := newFakeRedis(t)
+
+	store := NewRateLimitStore(RateLimitStoreSettings{
+		Type:     RedisRateLimitStoreType,
+		Address:  fr.Address(),
+		Password: "secret",
+		Database: 2,
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := store.Take(context.Background(), "client", RateLimitSettings{}.withDefaults()); err != nil {
+			t.Fatalf(`unexpected error %v`, err)
+		}
+	}
+
+	// The connection is authenticated once, then reused.
+	expected := []string{"AUTH", "SELECT", "EVALSHA", "EVAL", "EVALSHA"}
+	if commands := fr.Commands(); fmt.Sprint(commands) != fmt.Sprint(expected) {
+		t.Errorf(`expected %v but got %v`, expected, commands)
+	}
+}
+
+func Test_RateLimitStore_FailurePolicy(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	unreachable := listener.Addr().String()
+	listener.Close()
+
+	tests := []struct {
+		policy   RateLimitFailurePolicy
+		expected int
+	}{
+		{policy: "", expected: http.StatusOK},
+		{policy: FailOpenPolicy, expected: http.StatusOK},
+		{policy: FailClosedPolicy, expected: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			upstream := newTestUpstream(t, "ok", nil)
+
+			xy := &Server{}
+			xy.Proxyfile.Annotations.RateLimitingEnabled = true
+			xy.Proxyfile.Spec.Server.RateLimitStore = RateLimitStoreSettings{
+				Type:          RedisRateLimitStoreType,
+				Address:       unreachable,
+				FailurePolicy: tt.policy,
+			}
+			xy.registerRule(ProxyEndpointRule{
+				Host: "example.com",
+				Paths: []ProxyPath{{
+					Path:            "/",
+					PathType:        PrefixPathType,
+					Upstream:        &upstream,
+					EnableRateLimit: true,
+				}},
+			})
+
+			if status, _ := send(t, xy, newRequest(http.MethodGet, "/")); status != tt.expected {
+				t.Errorf(`expected %d but got %d`, tt.expected, status)
+			}
+		})
+	}
+}
+
+func Test_ReadRedisReply(t *testing.T) {
+	reply, err := readRedisReply(bufio.NewReader(strings.NewReader("*4\r\n+OK\r\n:42\r\n$5\r\nhello\r\n$-1\r\n")))
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	if expected := "[OK 42 hello <nil>]"; fmt.Sprint(reply) != expected {
+		t.Errorf(`expected %s but got %v`, expected, reply)
+	}
+
+	if _, err := readRedisReply(bufio.NewReader(strings.NewReader("-ERR boom\r\n"))); err != RedisError("ERR boom") {
+		t.Errorf(`expected redis error but got %v`, err)
+	}
+}
@@ -0,0 +1,250 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+func newTestRateLimiter(settings RateLimitSettings) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{current: time.Unix(0, 0)}
+
+	store := NewMemoryRateLimitStore()
+	store.now = clock.now
+
+	return NewRateLimiter(settings, "example.com/", store, nil), clock
+}
+
+// take - Takes a token, failing the test on store errors.
+func take(t *testing.T, rl *RateLimiter, key string) RateLimitDecision {
+	t.Helper()
+
+	decision, err := rl.Allow(context.Background(), key)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	return decision
+}
+
+func Test_RateLimiter_TokenBucket(t *testing.T) {
+	rl, clock := newTestRateLimiter(RateLimitSettings{Rate: 2, Period: time.Second, Burst: 3})
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		decision := take(t, rl, "client")
+		if !decision.Allowed || decision.Remaining != remaining || decision.Limit != 3 {
+			t.Fatalf(`expected burst request with %d remaining but got %+v`, remaining, decision)
+		}
+	}
+
+	decision := take(t, rl, "client")
+	if decision.Allowed || decision.RetryAfter != 500*time.Millisecond || decision.Reset != 1500*time.Millisecond {
+		t.Fatalf(`expected request to be limited for 500ms but got %+v`, decision)
+	}
+
+	clock.advance(499 * time.Millisecond)
+	if decision := take(t, rl, "client"); decision.Allowed {
+		t.Fatalf(`expected token not to be refilled yet but got %+v`, decision)
+	}
+
+	clock.advance(time.Millisecond)
+	if decision := take(t, rl, "client"); !decision.Allowed || decision.Remaining != 0 {
+		t.Fatalf(`expected a single refilled token but got %+v`, decision)
+	}
+
+	clock.advance(time.Minute)
+	if decision := take(t, rl, "client"); !decision.Allowed || decision.Remaining != 2 || decision.Reset != 500*time.Millisecond {
+		t.Fatalf(`expected a full bucket but got %+v`, decision)
+	}
+}
+
+func Test_RateLimiter_Keys(t *testing.T) {
+	rl, _ := newTestRateLimiter(RateLimitSettings{Rate: 1, Period: time.Minute})
+
+	if !take(t, rl, "a").Allowed || !take(t, rl, "b").Allowed {
+		t.Fatalf(`expected every key to have its own bucket`)
+	}
+
+	if take(t, rl, "a").Allowed {
+		t.Errorf(`expected key to be limited`)
+	}
+}
+
+func Test_RateLimitSettings_Defaults(t *testing.T) {
+	settings := RateLimitSettings{Rate: 10, Key: JWTClaimRateLimitKey}.withDefaults()
+
+	if settings.Burst != 10 || settings.Period != time.Second || settings.KeyName != "sub" {
+		t.Errorf(`unexpected defaults %+v`, settings)
+	}
+
+	if interval := settings.EmissionInterval(); interval != 100*time.Millisecond {
+		t.Errorf(`expected 100ms but got %v`, interval)
+	}
+}
+
+func Test_RateLimiter_HighRate(t *testing.T) {
+	// More requests than nanoseconds in the period.
+	rl, clock := newTestRateLimiter(RateLimitSettings{Rate: 2000, Period: time.Microsecond, Burst: 2})
+
+	if interval := rl.Settings.EmissionInterval(); interval != time.Nanosecond {
+		t.Errorf(`expected the interval to be clamped to 1ns but got %v`, interval)
+	}
+
+	if decision := take(t, rl, "client"); !decision.Allowed || decision.Remaining != 1 {
+		t.Fatalf(`expected the request to be allowed but got %+v`, decision)
+	}
+
+	take(t, rl, "client")
+	if decision := take(t, rl, "client"); decision.Allowed {
+		t.Fatalf(`expected the burst to be exhausted but got %+v`, decision)
+	}
+
+	clock.advance(time.Nanosecond)
+	if decision := take(t, rl, "client"); !decision.Allowed {
+		t.Errorf(`expected a refilled token but got %+v`, decision)
+	}
+}
+
+func Test_RateLimitSettings_RequestKey(t *testing.T) {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-1","tenant":42}`))
+	token := "Bearer eyJhbGciOiJIUzI1NiJ9." + claims + ".signature"
+
+	tests := []struct {
+		name     string
+		settings RateLimitSettings
+		headers  map[string]string
+		expected string
+	}{
+		{
+			name:     "client ip",
+			settings: RateLimitSettings{},
+			expected: "ip:0.0.0.0",
+		},
+		{
+			name:     "header",
+			settings: RateLimitSettings{Key: HeaderRateLimitKey, KeyName: "X-Tenant-Id"},
+			headers:  map[string]string{"X-Tenant-Id": "acme"},
+			expected: "header:acme",
+		},
+		{
+			name:     "api key",
+			settings: RateLimitSettings{Key: APIKeyRateLimitKey},
+			headers:  map[string]string{"X-Api-Key": "secret"},
+			expected: "api-key:secret",
+		},
+		{
+			name:     "jwt claim",
+			settings: RateLimitSettings{Key: JWTClaimRateLimitKey},
+			headers:  map[string]string{"Authorization": token},
+			expected: "jwt-claim:user-1",
+		},
+		{
+			name:     "numeric jwt claim",
+			settings: RateLimitSettings{Key: JWTClaimRateLimitKey, KeyName: "tenant"},
+			headers:  map[string]string{"Authorization": token},
+			expected: "jwt-claim:42",
+		},
+		{
+			name:     "malformed jwt falls back to ip",
+			settings: RateLimitSettings{Key: JWTClaimRateLimitKey},
+			headers:  map[string]string{"Authorization": "Bearer nope"},
+			expected: "ip:0.0.0.0",
+		},
+		{
+			name:     "missing header falls back to ip",
+			settings: RateLimitSettings{Key: HeaderRateLimitKey, KeyName: "X-Tenant-Id"},
+			expected: "ip:0.0.0.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+
+			ctx := &fasthttp.RequestCtx{}
+			for name, value := range tt.headers {
+				ctx.Request.Header.Set(name, value)
+			}
+
+			c := app.AcquireCtx(ctx)
+			defer app.ReleaseCtx(c)
+
+			if key := tt.settings.withDefaults().RequestKey(c, nil); key != tt.expected {
+				t.Errorf(`expected %s but got %s`, tt.expected, key)
+			}
+		})
+	}
+}
+
+func Test_RateLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation bool
+		path       bool
+		limited    bool
+	}{
+		{name: "enabled", annotation: true, path: true, limited: true},
+		{name: "disabled by annotation", annotation: false, path: true},
+		{name: "disabled by path", annotation: true, path: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newTestUpstream(t, "ok", nil)
+
+			xy := &Server{}
+			xy.Proxyfile.Annotations.RateLimitingEnabled = tt.annotation
+			xy.registerRule(ProxyEndpointRule{
+				Host: "example.com",
+				Paths: []ProxyPath{{
+					Path:            "/",
+					PathType:        PrefixPathType,
+					Upstream:        &upstream,
+					EnableRateLimit: tt.path,
+					RateLimit:       &RateLimitSettings{Rate: 1, Period: time.Hour, Burst: 2},
+				}},
+			})
+
+			for i := 0; i < 2; i++ {
+				if status, body := send(t, xy, newRequest(http.MethodGet, "/")); status != http.StatusOK || body != "ok" {
+					t.Fatalf(`expected request within burst to be proxied but got %d %s`, status, body)
+				}
+			}
+
+			response, err := xy.Hosts["example.com"].Fiber.Test(newRequest(http.MethodGet, "/"), -1)
+			if err != nil {
+				t.Fatalf(`unexpected error %v`, err)
+			}
+
+			if !tt.limited {
+				if response.StatusCode != http.StatusOK || response.Header.Get("RateLimit-Limit") != "" {
+					t.Errorf(`expected request not to be limited but got %d %v`, response.StatusCode, response.Header)
+				}
+
+				return
+			}
+
+			if response.StatusCode != http.StatusTooManyRequests {
+				t.Fatalf(`expected %d but got %d`, http.StatusTooManyRequests, response.StatusCode)
+			}
+
+			expected := map[string]string{
+				"RateLimit-Limit":     "2",
+				"RateLimit-Remaining": "0",
+				"RateLimit-Reset":     "7200",
+				"Retry-After":         "3600",
+			}
+
+			for name, value := range expected {
+				if actual := response.Header.Get(name); actual != value {
+					t.Errorf(`expected %s to be %s but got %s`, name, value, actual)
+				}
+			}
+		})
+	}
+}
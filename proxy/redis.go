@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Redis defaults
+	DefaultRedisTimeout  time.Duration = 100 * time.Millisecond
+	DefaultRedisPoolSize int           = 8
+)
+
+// RedisError - Error reply sent by a Redis server.
+type RedisError string
+
+func (e RedisError) Error() string { return string(e) }
+
+// RedisClient - Minimal RESP client for the few commands the proxy needs.
+//
+// Connections are pooled and dropped as soon as they fail.
+type RedisClient struct {
+	Address  string
+	Username string
+	Password string
+	Database int
+	Timeout  time.Duration
+
+	conns chan *redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisClient - Creates a Redis client (connections are established lazily).
+func NewRedisClient(settings RateLimitStoreSettings) *RedisClient {
+	if settings.Timeout <= 0 {
+		settings.Timeout = DefaultRedisTimeout
+	}
+
+	if settings.PoolSize <= 0 {
+		settings.PoolSize = DefaultRedisPoolSize
+	}
+
+	return &RedisClient{
+		Address:  settings.Address,
+		Username: settings.Username,
+		Password: settings.Password,
+		Database: settings.Database,
+		Timeout:  settings.Timeout,
+		conns:    make(chan *redisConn, settings.PoolSize),
+	}
+}
+
+// Do - Sends a command and reads its reply.
+//
+// Replies are decoded as string, int64, []any or nil. Error replies are returned as `RedisError`.
+func (rc *RedisClient) Do(ctx context.Context, args ...string) (any, error) {
+	conn, err := rc.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(rc.deadline(ctx), args...)
+
+	var redisErr RedisError
+	if err != nil && !errors.As(err, &redisErr) {
+		conn.Close()
+		return nil, err
+	}
+
+	rc.put(conn)
+
+	return reply, err
+}
+
+// Eval - Runs a Lua script, only sending its source if the server has not cached it yet.
+func (rc *RedisClient) Eval(ctx context.Context, script string, keys []string, args ...string) (any, error) {
+	digest := sha1.Sum([]byte(script))
+
+	command := append([]string{hex.EncodeToString(digest[:]), strconv.Itoa(len(keys))}, keys...)
+	command = append(command, args...)
+
+	reply, err := rc.Do(ctx, append([]string{"EVALSHA"}, command...)...)
+	if err == nil || !strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		return reply, err
+	}
+
+	command[0] = script
+	return rc.Do(ctx, append([]string{"EVAL"}, command...)...)
+}
+
+// Close - Closes every idle connection.
+func (rc *RedisClient) Close() {
+	for {
+		select {
+		case conn := <-rc.conns:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (rc *RedisClient) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-rc.conns:
+		return conn, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: rc.Timeout}
+
+	netConn, err := dialer.DialContext(ctx, "tcp", rc.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{Conn: netConn, reader: bufio.NewReader(netConn)}
+	deadline := rc.deadline(ctx)
+
+	if rc.Password != "" {
+		auth := []string{"AUTH", rc.Password}
+		if rc.Username != "" {
+			auth = []string{"AUTH", rc.Username, rc.Password}
+		}
+
+		if _, err := conn.do(deadline, auth...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if rc.Database != 0 {
+		if _, err := conn.do(deadline, "SELECT", strconv.Itoa(rc.Database)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (rc *RedisClient) put(conn *redisConn) {
+	select {
+	case rc.conns <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+func (rc *RedisClient) deadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(rc.Timeout)
+
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		return ctxDeadline
+	}
+
+	return deadline
+}
+
+func (conn *redisConn) do(deadline time.Time, args ...string) (any, error) {
+	conn.SetDeadline(deadline)
+
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if _, err := io.WriteString(conn, command.String()); err != nil {
+		return nil, err
+	}
+
+	return readRedisReply(conn.reader)
+}
+
+// readRedisReply - Decodes a single RESP value.
+func readRedisReply(reader *bufio.Reader) (any, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("malformed redis reply %q", line)
+	}
+
+	kind, value := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, RedisError(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+
+		buffer := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buffer); err != nil {
+			return nil, err
+		}
+
+		return string(buffer[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+
+		values := make([]any, size)
+		for i := range values {
+			values[i], err = readRedisReply(reader)
+
+			// Nested error replies are kept so the rest of the array is still consumed.
+			var redisErr RedisError
+			if errors.As(err, &redisErr) {
+				values[i] = redisErr
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unknown redis reply type %q", kind)
+	}
+}
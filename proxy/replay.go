@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -13,27 +14,124 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func (xy *Server) ReplayRequest(snapshot fiber.Ctx, proxyfile Proxyfile, path ProxyPath) error {
-	if !path.EnableReplay || !xy.Proxyfile.ReplayEnabled() {
-		return nil
+// dispatchReplay - Queues a captured request for every (sampled) replay target it matches.
+func (xy *Server) dispatchReplay(snapshot RequestSnapshot, targets []ReplayTarget) {
+	for _, target := range targets {
+		if target.Filter.Matches(snapshot) {
+			xy.ReplayDispatcher.Dispatch(snapshot, target)
+		}
 	}
+}
+
+// deliverReplay - Replays a request, retrying failed attempts and dead-lettering it if they all fail
+// (or `ctx` is done).
+func (xy *Server) deliverReplay(ctx context.Context, snapshot RequestSnapshot, target ReplayTarget) error {
+	request, err := xy.newReplayRequest(snapshot, target)
+	if err != nil {
+		logger.Logger.WithFields(logrus.Fields{
+			"request.id": snapshot.ID,
+			"target":     target.Name,
+			"error":      err,
+		}).Error("Invalid replay URL ❌")
+
+		xy.deadLetter(snapshot, target, 0, nil, err)
+
+		return err
+	}
+
+	client := xy.ReplayClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	policy := xy.Proxyfile.ReplayConfig().RetryPolicy()
+	comparator := xy.Comparators[target.Name]
+
+	for attempt := 1; ; attempt++ {
+		attemptRequest := request.Clone(ctx)
+		if request.GetBody != nil {
+			attemptRequest.Body, _ = request.GetBody()
+		}
+
+		reqTime := time.Now()
+		res, err := client.Do(attemptRequest)
+		duration := time.Since(reqTime)
+
+		var shadow *ResponseSnapshot
+
+		if err == nil {
+			shadow = comparator.capture(res)
+			discard(res)
+
+			if res.StatusCode < http.StatusInternalServerError {
+				logger.Logger.WithFields(logrus.Fields{
+					"request.id": snapshot.ID,
+					"target":     target.Name,
+					"duration":   duration.Nanoseconds(),
+					"url":        request.URL.String(),
+					"method":     request.Method,
+					"mode":       target.Mode,
+					"status":     res.StatusCode,
+					"attempt":    attempt,
+				}).Info("Replayed HTTP request ⏪")
+
+				comparator.Compare(snapshot, shadow)
+
+				return nil
+			}
+
+		}
+
+		failure := err
+		if failure == nil {
+			failure = fmt.Errorf("replay target responded with status %d", res.StatusCode)
+		}
+
+		fields := logrus.Fields{
+			"request.id": snapshot.ID,
+			"target":     target.Name,
+			"url":        request.URL.String(),
+			"method":     request.Method,
+			"attempt":    attempt,
+			"error":      failure,
+		}
+
+		if attempt < policy.Attempts && policy.Retryable(request.Method, res, err) {
+			logger.Logger.WithFields(fields).Info("Retrying HTTP replay 🔁")
+
+			// Retries are given up (and the replay dead-lettered) once `ctx` is done.
+			select {
+			case <-time.After(policy.Delay(attempt)):
+				continue
+			case <-ctx.Done():
+			}
+		}
+
+		logger.Logger.WithFields(fields).Error("HTTP replay failed ❌")
+
+		if res != nil {
+			comparator.Compare(snapshot, shadow)
+		}
 
-	reqTime := time.Now()
-	duration := time.Duration(time.Since(reqTime))
+		xy.deadLetter(snapshot, target, attempt, res, failure)
 
-	headers := map[string][]string{}
-	for k, v := range snapshot.GetReqHeaders() {
-		headers[k] = strings.Split(v, ",")
+		return failure
 	}
+}
 
-	headers["Content-Type"] = []string{"application/json"}
+// newReplayRequest - Request sent to a replay target for a captured request.
+func (xy *Server) newReplayRequest(snapshot RequestSnapshot, target ReplayTarget) (*http.Request, error) {
+	mode := target.Mode
 
-	for _, h := range xy.Proxyfile.ReplayConfig().SuppressedHeaders {
-		delete(headers, h.Name)
+	headers := snapshot.ForwardedHeader()
+	if mode != MirrorReplayMode {
+		headers.Set(fiber.HeaderContentType, "application/json")
 	}
 
-	host := xy.Proxyfile.ReplayConfig().Host + func() string {
-		port := xy.Proxyfile.ReplayConfig().Port
+	headers = suppressed(headers, target.SuppressedHeaders)
+
+	host := target.Host + func() string {
+		port := target.Port
 		if port > 0 {
 			return fmt.Sprintf(":%d", port)
 		}
@@ -41,76 +139,102 @@ func (xy *Server) ReplayRequest(snapshot fiber.Ctx, proxyfile Proxyfile, path Pr
 	}()
 
 	reqPath := func() string {
-		switch xy.Proxyfile.ReplayConfig().PathRewriteSettings.Strategy {
+		switch target.PathRewriteSettings.Strategy {
 		case RewritePathStrategy:
-			return xy.Proxyfile.ReplayConfig().PathRewriteSettings.Path
+			return target.PathRewriteSettings.Path
 		case SuppressPathStrategy:
 			return ""
 		default:
-			return snapshot.Path()
+			return snapshot.Path
 		}
 	}()
 
-	requestURL, err := url.Parse(xy.Proxyfile.ReplayConfig().Scheme + "://" + host + reqPath)
+	requestURL, err := url.Parse(target.Scheme + "://" + host + reqPath)
 	if err != nil {
-		logger.Logger.WithFields(logrus.Fields{
-			"request.id": snapshot.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader),
-			"url":        requestURL,
-			"error":      err,
-		}).Error("Invalid replay URL ❌")
+		return nil, err
+	}
 
-		return err
+	if mode == MirrorReplayMode {
+		requestURL.RawQuery = snapshot.RawQuery
 	}
 
 	method := func() string {
-		switch xy.Proxyfile.ReplayConfig().MethodRewriteSettings.Strategy {
+		switch target.MethodRewriteSettings.Strategy {
 		case RewriteMethodStrategy:
-			return xy.Proxyfile.ReplayConfig().MethodRewriteSettings.Method
+			return target.MethodRewriteSettings.Method
 		default:
-			return snapshot.Method()
+			return snapshot.Method
 		}
 	}()
 
-	data, _ := json.Marshal(map[string]any{
-		"body":      snapshot.Body(),
-		"path":      snapshot.Path(),
-		"method":    snapshot.Method(),
-		"headers":   snapshot.GetReqHeaders(),
-		"remote_ip": snapshot.Context().RemoteIP(),
-	})
+	data := func() []byte {
+		switch mode {
+		case MirrorReplayMode:
+			return snapshot.Body
+		default:
+			data, _ := json.Marshal(map[string]any{
+				"body":      snapshot.Body,
+				"path":      snapshot.Path,
+				"method":    snapshot.Method,
+				"headers":   envelopeHeaders(suppressed(snapshot.Header, target.SuppressedHeaders)),
+				"remote_ip": snapshot.RemoteIP,
+			})
+			return data
+		}
+	}()
 
-	res, err := http.DefaultClient.Do(&http.Request{
+	request := &http.Request{
 		Method: method,
 		Header: headers,
 		URL:    requestURL,
-		Body:   &RequestBody{Data: data},
-	})
+	}
 
-	status := -1
-	if err != nil {
+	NewRequestBody(data).Attach(request)
+
+	return request, nil
+}
+
+// deadLetter - Records a replay that failed on every attempt.
+func (xy *Server) deadLetter(snapshot RequestSnapshot, target ReplayTarget, attempts int, response *http.Response, err error) {
+	if xy.DeadLetters == nil {
+		return
+	}
+
+	letter := DeadLetter{Request: snapshot, Target: target.Name, Attempts: attempts, Error: err.Error(), FailedAt: time.Now()}
+	if response != nil {
+		letter.Status = response.StatusCode
+	}
+
+	if err := xy.DeadLetters.Append(letter); err != nil {
 		logger.Logger.WithFields(logrus.Fields{
-			"request.id": snapshot.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader),
-			"url":        requestURL,
-			"method":     method,
+			"request.id": snapshot.ID,
+			"file":       xy.DeadLetters.Path,
 			"error":      err,
-		}).Error("HTTP replay failed ❌")
-
-		return nil
+		}).Error("Unable to write dead letter 📭")
 	}
+}
 
-	status = res.StatusCode
-	defer res.Body.Close()
+// newReplayClient - Dedicated connection pool of the replay target.
+func newReplayClient(server ProxyServer) *http.Client {
+	return &http.Client{Transport: NewTransport(server.Timeouts, server.Transport.Merge(server.Replay.Transport))}
+}
 
-	fmt.Println(string(data))
+// suppressed - Headers without the ones suppressed for a replay target.
+func suppressed(header http.Header, suppressedHeaders []struct{ Name string }) http.Header {
+	header = header.Clone()
+	for _, h := range suppressedHeaders {
+		header.Del(h.Name)
+	}
 
-	logger.Logger.WithFields(logrus.Fields{
-		"request.id": snapshot.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader),
-		"duration":   duration.Nanoseconds(),
-		"url":        requestURL.String(),
-		"method":     method,
-		"status":     status,
-		"error":      err,
-	}).Info("Replayed HTTP request ⏪")
+	return header
+}
+
+// envelopeHeaders - Request headers as described by envelopes (one value per header).
+func envelopeHeaders(header http.Header) map[string]string {
+	headers := map[string]string{}
+	for name, values := range header {
+		headers[name] = strings.Join(values, ", ")
+	}
 
-	return nil
+	return headers
 }
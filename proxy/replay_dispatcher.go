@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cleopatrio/proxy/logger"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Replay drop policies
+	DropNewestReplayPolicy ReplayDropPolicy = "drop-newest"
+	DropOldestReplayPolicy ReplayDropPolicy = "drop-oldest"
+	BlockReplayPolicy      ReplayDropPolicy = "block"
+
+	// Replay dispatcher defaults
+	DefaultReplayWorkers      int           = 4
+	DefaultReplayQueueSize    int           = 1000
+	DefaultReplayDrainTimeout time.Duration = 10 * time.Second
+)
+
+// ReplayDropPolicy - What happens to a replayed request when the queue is full.
+type ReplayDropPolicy string
+
+// ReplayDispatcherSettings - Bounds the resources used to replay requests.
+type ReplayDispatcherSettings struct {
+	// Requests replayed concurrently.
+	Workers int `yaml:"workers" example:"4"`
+
+	// Requests waiting for a worker.
+	QueueSize int `yaml:"queueSize" example:"1000"`
+
+	// What happens when the queue is full [drop-newest/drop-oldest/block] (defaults to drop-newest).
+	// 	- block: the proxied request waits for room in the queue.
+	DropPolicy ReplayDropPolicy `yaml:"dropPolicy" example:"drop-newest"`
+
+	// Time given to the queue to drain on shutdown.
+	DrainTimeout time.Duration `yaml:"drainTimeout" example:"10s"`
+}
+
+func (rs ReplayDispatcherSettings) withDefaults() ReplayDispatcherSettings {
+	if rs.Workers <= 0 {
+		rs.Workers = DefaultReplayWorkers
+	}
+
+	if rs.QueueSize <= 0 {
+		rs.QueueSize = DefaultReplayQueueSize
+	}
+
+	if rs.DropPolicy == "" {
+		rs.DropPolicy = DropNewestReplayPolicy
+	}
+
+	if rs.DrainTimeout <= 0 {
+		rs.DrainTimeout = DefaultReplayDrainTimeout
+	}
+
+	return rs
+}
+
+// ReplayReport - Counters of a replay dispatcher.
+type ReplayReport struct {
+	Workers   int `json:"workers"`
+	QueueSize int `json:"queueSize"`
+
+	// Requests waiting for a worker.
+	QueueDepth int `json:"queueDepth"`
+
+	Enqueued  int64 `json:"enqueued"`
+	Dropped   int64 `json:"dropped"`
+	Completed int64 `json:"completed"`
+}
+
+// ReplayFunc - Replays a captured request to a target (giving up once `ctx` is done).
+type ReplayFunc func(ctx context.Context, snapshot RequestSnapshot, target ReplayTarget)
+
+// ReplayDispatcher - Replays requests with a fixed number of workers fed by a bounded queue.
+type ReplayDispatcher struct {
+	Settings ReplayDispatcherSettings
+
+	replay ReplayFunc
+	queue  chan replayJob
+
+	// Done once the drain timeout expires.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Guards sending to the queue against it being closed.
+	mu      sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
+
+	enqueued  int64
+	dropped   int64
+	completed int64
+}
+
+type replayJob struct {
+	snapshot RequestSnapshot
+	target   ReplayTarget
+}
+
+// NewReplayDispatcher - Starts the workers of a dispatcher.
+func NewReplayDispatcher(settings ReplayDispatcherSettings, replay ReplayFunc) *ReplayDispatcher {
+	settings = settings.withDefaults()
+
+	rd := &ReplayDispatcher{Settings: settings, replay: replay, queue: make(chan replayJob, settings.QueueSize)}
+	rd.ctx, rd.cancel = context.WithCancel(context.Background())
+
+	for i := 0; i < settings.Workers; i++ {
+		rd.workers.Add(1)
+		go rd.work()
+	}
+
+	return rd
+}
+
+// Dispatch - Queues a request for replay, returning false if it was dropped.
+func (rd *ReplayDispatcher) Dispatch(snapshot RequestSnapshot, target ReplayTarget) bool {
+	if rd == nil {
+		return false
+	}
+
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+
+	job := replayJob{snapshot: snapshot, target: target}
+
+	if rd.closed {
+		rd.drop(job, "closed")
+		return false
+	}
+
+	switch rd.Settings.DropPolicy {
+	case BlockReplayPolicy:
+		rd.queue <- job
+
+	case DropOldestReplayPolicy:
+		for {
+			select {
+			case rd.queue <- job:
+				atomic.AddInt64(&rd.enqueued, 1)
+				return true
+			default:
+			}
+
+			select {
+			case oldest := <-rd.queue:
+				rd.drop(oldest, "full")
+			default:
+			}
+		}
+
+	default:
+		select {
+		case rd.queue <- job:
+		default:
+			rd.drop(job, "full")
+			return false
+		}
+	}
+
+	atomic.AddInt64(&rd.enqueued, 1)
+
+	return true
+}
+
+// Close - Stops accepting requests and waits (up to the drain timeout) for queued ones to be replayed.
+// Replays still pending after the drain timeout are given up (and dead-lettered), returning false.
+func (rd *ReplayDispatcher) Close() bool {
+	if rd == nil {
+		return true
+	}
+
+	rd.mu.Lock()
+	if !rd.closed {
+		rd.closed = true
+		close(rd.queue)
+	}
+	rd.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		rd.workers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return true
+	case <-time.After(rd.Settings.DrainTimeout):
+		logger.Logger.WithFields(logrus.Fields{
+			"queue.depth": len(rd.queue),
+		}).Warn("Replay queue was not drained in time 🚰")
+
+		// Pending replays give up and are dead-lettered (which is given as much time).
+		rd.cancel()
+
+		select {
+		case <-drained:
+		case <-time.After(rd.Settings.DrainTimeout):
+		}
+
+		return false
+	}
+}
+
+// Report - Current counters.
+func (rd *ReplayDispatcher) Report() ReplayReport {
+	return ReplayReport{
+		Workers:    rd.Settings.Workers,
+		QueueSize:  rd.Settings.QueueSize,
+		QueueDepth: len(rd.queue),
+		Enqueued:   atomic.LoadInt64(&rd.enqueued),
+		Dropped:    atomic.LoadInt64(&rd.dropped),
+		Completed:  atomic.LoadInt64(&rd.completed),
+	}
+}
+
+func (rd *ReplayDispatcher) work() {
+	defer rd.workers.Done()
+
+	for job := range rd.queue {
+		rd.replay(rd.ctx, job.snapshot, job.target)
+		atomic.AddInt64(&rd.completed, 1)
+	}
+}
+
+func (rd *ReplayDispatcher) drop(job replayJob, reason string) {
+	atomic.AddInt64(&rd.dropped, 1)
+
+	logger.Logger.WithFields(logrus.Fields{
+		"request.id": job.snapshot.ID,
+		"target":     job.target.Name,
+		"reason":     reason,
+		"policy":     rd.Settings.DropPolicy,
+	}).Debug("Dropped replayed request 🗑")
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// blockedReplays - Replay function recording request ids, blocked until released.
+type blockedReplays struct {
+	mu       sync.Mutex
+	ids      []string
+	started  chan string
+	released chan struct{}
+}
+
+func newBlockedReplays() *blockedReplays {
+	return &blockedReplays{started: make(chan string, 100), released: make(chan struct{})}
+}
+
+func (br *blockedReplays) replay(_ context.Context, snapshot RequestSnapshot, _ ReplayTarget) {
+	br.started <- snapshot.ID
+	<-br.released
+
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	br.ids = append(br.ids, snapshot.ID)
+}
+
+func (br *blockedReplays) replayed() []string {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+
+	return append([]string{}, br.ids...)
+}
+
+// dispatch - Dispatches requests with the given ids, waiting for the first one to reach the single worker.
+func dispatch(t *testing.T, rd *ReplayDispatcher, replays *blockedReplays, ids ...string) []bool {
+	t.Helper()
+
+	queued := []bool{}
+	for i, id := range ids {
+		queued = append(queued, rd.Dispatch(RequestSnapshot{ID: id}, ReplayTarget{}))
+
+		if i == 0 {
+			select {
+			case <-replays.started:
+			case <-time.After(time.Second):
+				t.Fatalf(`expected the worker to pick up %s`, id)
+			}
+		}
+	}
+
+	return queued
+}
+
+func Test_ReplayDispatcher_DropPolicies(t *testing.T) {
+	for policy, expected := range map[ReplayDropPolicy]struct {
+		replayed []string
+		enqueued int64
+	}{
+		DropNewestReplayPolicy: {replayed: []string{"a", "b", "c"}, enqueued: 3},
+		// `b` was queued before being dropped.
+		DropOldestReplayPolicy: {replayed: []string{"a", "c", "d"}, enqueued: 4},
+	} {
+		replays := newBlockedReplays()
+		rd := NewReplayDispatcher(ReplayDispatcherSettings{Workers: 1, QueueSize: 2, DropPolicy: policy}, replays.replay)
+
+		// `a` is being replayed, `b` and `c` fill the queue.
+		queued := dispatch(t, rd, replays, "a", "b", "c", "d")
+
+		if report := rd.Report(); report.QueueDepth != 2 || report.Dropped != 1 {
+			t.Errorf(`%s: unexpected report %+v`, policy, report)
+		}
+
+		if queued[3] != (policy == DropOldestReplayPolicy) {
+			t.Errorf(`%s: unexpected dispatch results %v`, policy, queued)
+		}
+
+		close(replays.released)
+
+		if !rd.Close() {
+			t.Errorf(`%s: expected the queue to be drained`, policy)
+		}
+
+		if replayed := replays.replayed(); strings.Join(replayed, ",") != strings.Join(expected.replayed, ",") {
+			t.Errorf(`%s: expected %v to be replayed but got %v`, policy, expected.replayed, replayed)
+		}
+
+		if report := rd.Report(); report != (ReplayReport{Workers: 1, QueueSize: 2, Enqueued: expected.enqueued, Dropped: 1, Completed: 3}) {
+			t.Errorf(`%s: unexpected report %+v`, policy, report)
+		}
+	}
+}
+
+func Test_ReplayDispatcher_Block(t *testing.T) {
+	replays := newBlockedReplays()
+	rd := NewReplayDispatcher(ReplayDispatcherSettings{Workers: 1, QueueSize: 1, DropPolicy: BlockReplayPolicy}, replays.replay)
+
+	dispatch(t, rd, replays, "a", "b")
+
+	blocked := make(chan bool)
+	go func() { blocked <- rd.Dispatch(RequestSnapshot{ID: "c"}, ReplayTarget{}) }()
+
+	select {
+	case <-blocked:
+		t.Fatalf(`expected dispatching to wait for room in the queue`)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(replays.released)
+
+	if queued := <-blocked; !queued {
+		t.Errorf(`expected the request to be queued once there is room`)
+	}
+
+	rd.Close()
+
+	if replayed := replays.replayed(); len(replayed) != 3 || rd.Report().Dropped != 0 {
+		t.Errorf(`expected every request to be replayed but got %v`, replayed)
+	}
+}
+
+func Test_ReplayDispatcher_Close(t *testing.T) {
+	replays := newBlockedReplays()
+	rd := NewReplayDispatcher(ReplayDispatcherSettings{Workers: 1, QueueSize: 10, DrainTimeout: 20 * time.Millisecond}, replays.replay)
+
+	dispatch(t, rd, replays, "a", "b")
+
+	// The worker is stuck, the queue cannot be drained in time.
+	if rd.Close() {
+		t.Errorf(`expected the drain to time out`)
+	}
+
+	if rd.Dispatch(RequestSnapshot{ID: "c"}, ReplayTarget{}) {
+		t.Errorf(`expected requests to be dropped once closed`)
+	}
+
+	close(replays.released)
+
+	rd.Settings.DrainTimeout = time.Second
+	if !rd.Close() || len(replays.replayed()) != 2 {
+		t.Errorf(`expected queued requests to be replayed but got %v`, replays.replayed())
+	}
+
+	var nilDispatcher *ReplayDispatcher
+	if nilDispatcher.Dispatch(RequestSnapshot{}, ReplayTarget{}) || !nilDispatcher.Close() {
+		t.Errorf(`expected a nil dispatcher to drop requests`)
+	}
+}
+
+func Test_ReplayDispatcher_Cancel(t *testing.T) {
+	cancelled := make(chan string, 2)
+
+	rd := NewReplayDispatcher(ReplayDispatcherSettings{Workers: 1, DrainTimeout: 20 * time.Millisecond}, func(ctx context.Context, snapshot RequestSnapshot, _ ReplayTarget) {
+		<-ctx.Done()
+		cancelled <- snapshot.ID
+	})
+
+	rd.Dispatch(RequestSnapshot{ID: "a"}, ReplayTarget{})
+	rd.Dispatch(RequestSnapshot{ID: "b"}, ReplayTarget{})
+
+	if rd.Close() {
+		t.Errorf(`expected the drain to time out`)
+	}
+
+	// Replays left behind were given up instead of being abandoned.
+	if len(cancelled) != 2 {
+		t.Errorf(`expected pending replays to be given up but got %d`, len(cancelled))
+	}
+}
+
+func Test_ReplayHandler(t *testing.T) {
+	xy := &Server{}
+	xy.Proxyfile.Annotations.ReplayRequestsEnabled = true
+	xy.Proxyfile.Spec.Server.Replay.Dispatcher = ReplayDispatcherSettings{Workers: 2, QueueSize: 5}
+	xy.registerRule(ProxyEndpointRule{Host: "example.com"})
+
+	t.Cleanup(func() { xy.ReplayDispatcher.Close() })
+
+	app := fiber.New()
+	app.Get("/admin/replay", xy.replayHandler)
+
+	response, err := app.Test(newRequest(http.MethodGet, "/admin/replay"), -1)
+	if err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	report := ReplayReport{}
+	if err := json.NewDecoder(response.Body).Decode(&report); err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	if report != (ReplayReport{Workers: 2, QueueSize: 5}) {
+		t.Errorf(`unexpected report %+v`, report)
+	}
+}
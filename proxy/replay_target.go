@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/cleopatrio/proxy/logger"
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultReplayTargetName string = "default"
+
+// ReplayTarget - Destination of replayed requests.
+type ReplayTarget struct {
+	// Identifies the target in logs and dead letters (defaults to its position).
+	Name string `yaml:"name" example:"analytics"`
+
+	// Replayed requests will be sent using this protocol [http/https]
+	Scheme string `yaml:"scheme"`
+
+	// Replayed requests will be sent to this host.
+	Host string `yaml:"host"`
+
+	// Replayed requests will be sent to this port.
+	Port int `yaml:"port"`
+
+	// How requests are replayed [envelope/mirror] (defaults to envelope).
+	// 	- envelope: the request is described by a JSON document.
+	// 	- mirror: the original query, headers and body are sent as is (traffic shadowing).
+	Mode ReplayMode `yaml:"mode"`
+
+	// Replayed requests will not include these headers.
+	SuppressedHeaders []struct{ Name string } `yaml:"suppressedHeaders"`
+
+	MethodRewriteSettings struct {
+		Strategy MethodRewriteStrategy
+		Method   string
+	} `yaml:"methodRewriteSettings"`
+
+	PathRewriteSettings struct {
+		Strategy PathRewriteStrategy
+		Path     string
+	} `yaml:"pathRewriteSettings"`
+
+	// Requests sent to this target (every request if absent).
+	Filter ReplayFilter `yaml:"filter"`
+
+	// Share of the requests sent to this target.
+	ReplaySampling `yaml:",inline"`
+
+	// Compares the target's responses with the primary ones (disabled if absent).
+	Compare *ShadowComparison `yaml:"compare"`
+}
+
+func (rt ReplayTarget) withDefaults(name string) ReplayTarget {
+	if rt.Name == "" {
+		rt.Name = name
+	}
+
+	if rt.Scheme == "" {
+		rt.Scheme = "http"
+	}
+
+	if rt.Mode == "" {
+		rt.Mode = EnvelopeReplayMode
+	}
+
+	return rt
+}
+
+// ReplayFilter - Selects the requests sent to a replay target (all criteria must match).
+type ReplayFilter struct {
+	// Glob matched against the request path (`*` within a segment, `**` across segments).
+	Path string `yaml:"path" example:"/api/**"`
+
+	// Request methods (any if empty).
+	Methods []string `yaml:"methods" example:"[POST, PUT]"`
+
+	// Request headers that must all match.
+	Headers []*HeaderMatcher `yaml:"headers"`
+
+	// Classes of the status sent back to the client [1xx/2xx/3xx/4xx/5xx] (any if empty).
+	StatusClasses []string `yaml:"statusClasses" example:"[5xx]"`
+}
+
+// Matches - Checks if a request (and the status it was answered with) is selected.
+func (rf ReplayFilter) Matches(snapshot RequestSnapshot) bool {
+	if rf.Path != "" && !matchGlob(rf.Path, snapshot.Path) {
+		return false
+	}
+
+	if len(rf.Methods) > 0 && !containsFold(rf.Methods, snapshot.Method) {
+		return false
+	}
+
+	for _, matcher := range rf.Headers {
+		if !matcher.Matches(snapshot.Header) {
+			return false
+		}
+	}
+
+	if len(rf.StatusClasses) > 0 && !containsFold(rf.StatusClasses, fmt.Sprintf("%dxx", snapshot.Status/100)) {
+		return false
+	}
+
+	return true
+}
+
+// HeaderMatcher - Matches a request header.
+//
+// The header must be present, and equal to `value` or match `regex` when they are set.
+type HeaderMatcher struct {
+	Name  string `yaml:"name" example:"X-Debug"`
+	Value string `yaml:"value" example:"true"`
+	Regex string `yaml:"regex" example:"^Bearer "`
+
+	once     sync.Once
+	compiled *regexp.Regexp
+}
+
+// Matches - Checks if any value of the header matches.
+func (hm *HeaderMatcher) Matches(headers http.Header) bool {
+	for _, value := range headers.Values(hm.Name) {
+		switch {
+		case hm.Value != "" && value != hm.Value:
+		case hm.Regex != "" && (hm.regex() == nil || !hm.regex().MatchString(value)):
+		default:
+			return true
+		}
+	}
+
+	return false
+}
+
+// regex - Compiles the expression once (nil if absent or invalid, which matches nothing).
+func (hm *HeaderMatcher) regex() *regexp.Regexp {
+	hm.once.Do(func() {
+		compiled, err := regexp.Compile(hm.Regex)
+		if err != nil {
+			logger.Logger.
+				WithFields(logrus.Fields{"header": hm.Name, "regex": hm.Regex, "error": err}).
+				Error("Invalid header matcher expression")
+
+			return
+		}
+
+		hm.compiled = compiled
+	})
+
+	return hm.compiled
+}
+
+// matchGlob - Matches a path against a glob where `**` spans any number of segments.
+func matchGlob(pattern, requestPath string) bool {
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(strings.Trim(requestPath, "/"), "/"))
+}
+
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+
+	matched, _ := path.Match(pattern[0], segments[0])
+
+	return matched && matchSegments(pattern[1:], segments[1:])
+}
+
+func containsFold(collection []string, element string) bool {
+	for _, candidate := range collection {
+		if strings.EqualFold(candidate, element) {
+			return true
+		}
+	}
+
+	return false
+}
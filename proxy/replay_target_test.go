@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func Test_ReplayFilter_Matches(t *testing.T) {
+	snapshot := RequestSnapshot{
+		Method: http.MethodPost,
+		Path:   "/api/v1/orders/42",
+		Header: http.Header{"Authorization": {"Bearer token"}, "X-Debug": {"true"}},
+		Status: http.StatusBadGateway,
+	}
+
+	tests := []struct {
+		name    string
+		filter  ReplayFilter
+		matches bool
+	}{
+		{name: "empty filter", filter: ReplayFilter{}, matches: true},
+		{name: "exact path", filter: ReplayFilter{Path: "/api/v1/orders/42"}, matches: true},
+		{name: "segment wildcard", filter: ReplayFilter{Path: "/api/*/orders/*"}, matches: true},
+		{name: "segment wildcard does not span segments", filter: ReplayFilter{Path: "/api/*"}, matches: false},
+		{name: "double wildcard", filter: ReplayFilter{Path: "/api/**"}, matches: true},
+		{name: "double wildcard in the middle", filter: ReplayFilter{Path: "/**/orders/*"}, matches: true},
+		{name: "other path", filter: ReplayFilter{Path: "/admin/**"}, matches: false},
+		{name: "method", filter: ReplayFilter{Methods: []string{"get", "post"}}, matches: true},
+		{name: "other method", filter: ReplayFilter{Methods: []string{http.MethodGet}}, matches: false},
+		{name: "header present", filter: ReplayFilter{Headers: []*HeaderMatcher{{Name: "x-debug"}}}, matches: true},
+		{name: "header missing", filter: ReplayFilter{Headers: []*HeaderMatcher{{Name: "X-Trace"}}}, matches: false},
+		{name: "header value", filter: ReplayFilter{Headers: []*HeaderMatcher{{Name: "X-Debug", Value: "true"}}}, matches: true},
+		{name: "other header value", filter: ReplayFilter{Headers: []*HeaderMatcher{{Name: "X-Debug", Value: "false"}}}, matches: false},
+		{name: "header regex", filter: ReplayFilter{Headers: []*HeaderMatcher{{Name: "Authorization", Regex: "^Bearer "}}}, matches: true},
+		{name: "invalid header regex", filter: ReplayFilter{Headers: []*HeaderMatcher{{Name: "Authorization", Regex: "("}}}, matches: false},
+		{name: "status class", filter: ReplayFilter{StatusClasses: []string{"4xx", "5XX"}}, matches: true},
+		{name: "other status class", filter: ReplayFilter{StatusClasses: []string{"2xx"}}, matches: false},
+		{name: "every criteria", filter: ReplayFilter{Path: "/api/**", Methods: []string{http.MethodPost}, StatusClasses: []string{"5xx"}}, matches: true},
+		{name: "one failing criteria", filter: ReplayFilter{Path: "/api/**", Methods: []string{http.MethodPut}, StatusClasses: []string{"5xx"}}, matches: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if matches := tt.filter.Matches(snapshot); matches != tt.matches {
+				t.Errorf(`expected match to be %v but got %v`, tt.matches, matches)
+			}
+		})
+	}
+}
+
+func Test_ReplayRequest_Targets(t *testing.T) {
+	analytics, analyticsReceived := newReplayTarget(t)
+	debug, debugReceived := newReplayTarget(t)
+	unsampled, unsampledReceived := newReplayTarget(t)
+
+	analytics.Mode, debug.Mode, unsampled.Mode = MirrorReplayMode, MirrorReplayMode, MirrorReplayMode
+	debug.Filter = ReplayFilter{StatusClasses: []string{"5xx"}}
+
+	never := 0.0
+	unsampled.SampleRate = &never
+
+	xy := &Server{}
+	xy.Proxyfile.Annotations.ReplayRequestsEnabled = true
+	xy.Proxyfile.Spec.Server.Replay = ProxyReplay{Targets: []ReplayTarget{analytics.ReplayTarget, debug.ReplayTarget, unsampled.ReplayTarget}}
+
+	tests := []struct {
+		status int
+		debug  bool
+	}{
+		{status: http.StatusOK, debug: false},
+		{status: http.StatusServiceUnavailable, debug: true},
+	}
+
+	for _, tt := range tests {
+		snapshot := RequestSnapshot{Method: http.MethodGet, Path: "/orders", Header: http.Header{}, Status: tt.status}
+		if err := replaySnapshot(xy, snapshot); err != nil {
+			t.Fatalf(`unexpected error %v`, err)
+		}
+
+		if len(analyticsReceived) != 1 {
+			t.Errorf(`expected a %d response to be replayed to the analytics target`, tt.status)
+		}
+
+		if replayed := len(debugReceived) == 1; replayed != tt.debug {
+			t.Errorf(`expected a %d response to be replayed to the debug target: %v`, tt.status, tt.debug)
+		}
+
+		if len(unsampledReceived) != 0 {
+			t.Errorf(`expected a %d response not to be replayed to the unsampled target`, tt.status)
+		}
+
+		for len(analyticsReceived) > 0 {
+			<-analyticsReceived
+		}
+
+		for len(debugReceived) > 0 {
+			<-debugReceived
+		}
+	}
+}
+
+func Test_ProxyReplay_ReplayTargets(t *testing.T) {
+	legacy := ProxyReplay{}
+	if err := yaml.Unmarshal([]byte("host: replay.local\nport: 8081\nmode: mirror\n"), &legacy); err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	targets := legacy.ReplayTargets()
+	if len(targets) != 1 || targets[0].Name != DefaultReplayTargetName || targets[0].Host != "replay.local" || targets[0].Port != 8081 || targets[0].Mode != MirrorReplayMode || targets[0].Scheme != "http" {
+		t.Errorf(`expected the inline settings to be the default target but got %+v`, targets)
+	}
+
+	multiple := ProxyReplay{}
+	document := `
+targets:
+  - name: analytics
+    host: analytics.local
+  - host: debug.local
+    filter:
+      path: /api/**
+      headers:
+        - name: X-Debug
+          value: "true"
+      statusClasses: [5xx]
+`
+	if err := yaml.Unmarshal([]byte(document), &multiple); err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	targets = multiple.ReplayTargets()
+	if len(targets) != 2 || targets[0].Name != "analytics" || targets[1].Name != "target-2" || targets[1].Mode != EnvelopeReplayMode {
+		t.Fatalf(`expected two named targets but got %+v`, targets)
+	}
+
+	if targets[1].Filter.Path != "/api/**" || len(targets[1].Filter.Headers) != 1 || targets[1].Filter.Headers[0].Value != "true" {
+		t.Errorf(`expected the target filter to be decoded but got %+v`, targets[1].Filter)
+	}
+}
@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// replayed - Request received by a replay target.
+type replayed struct {
+	Method     string
+	RequestURI string
+	Header     http.Header
+	Body       []byte
+}
+
+// newReplayTarget - Replay settings sending requests to a target recording them.
+func newReplayTarget(t *testing.T) (ProxyReplay, chan replayed) {
+	t.Helper()
+
+	received := make(chan replayed, 1)
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- replayed{Method: r.Method, RequestURI: r.RequestURI, Header: r.Header.Clone(), Body: body}
+	}))
+	t.Cleanup(target.Close)
+
+	address, _ := url.Parse(target.URL)
+	port, _ := strconv.Atoi(address.Port())
+
+	return ProxyReplay{ReplayTarget: ReplayTarget{Scheme: "http", Host: address.Hostname(), Port: port}}, received
+}
+
+// replaySnapshot - Replays a captured request to every target sampling and matching it, as the proxy
+// does, and waits for the replays (returning the first failure).
+func replaySnapshot(xy *Server, snapshot RequestSnapshot) error {
+	var mu sync.Mutex
+	var failure error
+
+	xy.ReplayDispatcher = NewReplayDispatcher(ReplayDispatcherSettings{Workers: 1}, func(ctx context.Context, snapshot RequestSnapshot, target ReplayTarget) {
+		if err := xy.deliverReplay(ctx, snapshot, target); err != nil {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if failure == nil {
+				failure = err
+			}
+		}
+	})
+
+	app := fiber.New()
+
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.Header.SetMethod(snapshot.Method)
+	fctx.Request.SetRequestURI(snapshot.Path)
+	for name, values := range snapshot.Header {
+		for _, value := range values {
+			fctx.Request.Header.Add(name, value)
+		}
+	}
+
+	c := app.AcquireCtx(fctx)
+	c.Set(PxFile.Annotations.HTTPRequestIdHeader, snapshot.ID)
+
+	targets := xy.sampledReplayTargets(c, ProxyPath{EnableReplay: true})
+	app.ReleaseCtx(c)
+
+	xy.dispatchReplay(snapshot, targets)
+	xy.ReplayDispatcher.Close()
+
+	return failure
+}
+
+// replay - Replays a request synchronously and returns what the target received.
+func replay(t *testing.T, settings ProxyReplay, received chan replayed, request *http.Request) replayed {
+	t.Helper()
+
+	xy := &Server{}
+	xy.Proxyfile.Annotations.ReplayRequestsEnabled = true
+	xy.Proxyfile.Spec.Server.Replay = settings
+
+	app := fiber.New()
+	app.All("/*", func(c *fiber.Ctx) error {
+		return replaySnapshot(xy, NewRequestSnapshot(c, xy.Forwarding))
+	})
+
+	if _, err := app.Test(request, -1); err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	select {
+	case request := <-received:
+		return request
+	default:
+		t.Fatalf(`expected the request to be replayed`)
+		return replayed{}
+	}
+}
+
+func Test_ReplayRequest_Mirror(t *testing.T) {
+	settings, received := newReplayTarget(t)
+	settings.Mode = MirrorReplayMode
+	settings.SuppressedHeaders = []struct{ Name string }{{Name: "X-Secret"}}
+
+	request := httptest.NewRequest(http.MethodPost, "http://example.com/orders?id=1&sort=desc", bytes.NewReader(payload))
+	request.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	request.Header.Set("X-Custom", "value")
+	request.Header.Set("X-Secret", "token")
+
+	mirrored := replay(t, settings, received, request)
+
+	if mirrored.Method != http.MethodPost || mirrored.RequestURI != "/orders?id=1&sort=desc" {
+		t.Errorf(`expected the original method and URI but got %s %s`, mirrored.Method, mirrored.RequestURI)
+	}
+
+	if mirrored.Header.Get(fiber.HeaderContentType) != fiber.MIMEApplicationForm || mirrored.Header.Get("X-Custom") != "value" {
+		t.Errorf(`expected the original headers but got %v`, mirrored.Header)
+	}
+
+	if !bytes.Equal(mirrored.Body, payload) || mirrored.Header.Get(fiber.HeaderContentLength) != strconv.Itoa(len(payload)) {
+		t.Errorf(`expected the raw body but got %d bytes (Content-Length %s)`, len(mirrored.Body), mirrored.Header.Get(fiber.HeaderContentLength))
+	}
+
+	if mirrored.Header.Get("X-Secret") != "" {
+		t.Errorf(`expected suppressed headers to be dropped but got %v`, mirrored.Header)
+	}
+}
+
+func Test_ReplayRequest_MirrorRewrites(t *testing.T) {
+	settings, received := newReplayTarget(t)
+	settings.Mode = MirrorReplayMode
+	settings.MethodRewriteSettings.Strategy = RewriteMethodStrategy
+	settings.MethodRewriteSettings.Method = http.MethodPut
+	settings.PathRewriteSettings.Strategy = RewritePathStrategy
+	settings.PathRewriteSettings.Path = "/shadow"
+
+	mirrored := replay(t, settings, received, newRequest(http.MethodGet, "/orders?id=1"))
+
+	if mirrored.Method != http.MethodPut || mirrored.RequestURI != "/shadow?id=1" {
+		t.Errorf(`expected PUT /shadow?id=1 but got %s %s`, mirrored.Method, mirrored.RequestURI)
+	}
+}
+
+func Test_ReplayRequest_Envelope(t *testing.T) {
+	settings, received := newReplayTarget(t)
+	settings.SuppressedHeaders = []struct{ Name string }{{Name: "Authorization"}}
+
+	request := httptest.NewRequest(http.MethodPost, "http://example.com/orders?id=1", bytes.NewReader(payload))
+	request.Header.Set(fiber.HeaderContentType, fiber.MIMETextPlain)
+	request.Header.Set(fiber.HeaderAuthorization, "Bearer secret")
+
+	enveloped := replay(t, settings, received, request)
+
+	if enveloped.Method != http.MethodPost || enveloped.RequestURI != "/orders" {
+		t.Errorf(`expected POST /orders but got %s %s`, enveloped.Method, enveloped.RequestURI)
+	}
+
+	envelope := struct {
+		Body    []byte            `json:"body"`
+		Method  string            `json:"method"`
+		Path    string            `json:"path"`
+		Headers map[string]string `json:"headers"`
+	}{}
+
+	if err := json.Unmarshal(enveloped.Body, &envelope); err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	if !bytes.Equal(envelope.Body, payload) || envelope.Method != http.MethodPost || envelope.Path != "/orders" {
+		t.Errorf(`unexpected envelope %s %s with %d bytes`, envelope.Method, envelope.Path, len(envelope.Body))
+	}
+
+	if envelope.Headers[fiber.HeaderContentType] != fiber.MIMETextPlain || envelope.Headers[fiber.HeaderAuthorization] != "" {
+		t.Errorf(`expected the envelope to describe every header but the suppressed ones, got %v`, envelope.Headers)
+	}
+
+	if enveloped.Header.Get(fiber.HeaderContentType) != fiber.MIMEApplicationJSON || enveloped.Header.Get(fiber.HeaderAuthorization) != "" {
+		t.Errorf(`expected a JSON envelope but got %v`, enveloped.Header)
+	}
+}
+
+func Test_ReplayRequest_Concurrent(t *testing.T) {
+	const requests = 100
+
+	mu := sync.Mutex{}
+	mirrored := map[string]string{}
+	done := make(chan struct{})
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		mirrored[r.Method+" "+r.RequestURI+" "+r.Header.Get("X-Request")] = string(body)
+		if len(mirrored) == requests {
+			close(done)
+		}
+	}))
+	t.Cleanup(target.Close)
+
+	address, _ := url.Parse(target.URL)
+	port, _ := strconv.Atoi(address.Port())
+
+	xy := &Server{}
+	xy.Proxyfile.Annotations.ReplayRequestsEnabled = true
+	xy.Proxyfile.Spec.Server.Replay = ProxyReplay{ReplayTarget: ReplayTarget{Scheme: "http", Host: address.Hostname(), Port: port, Mode: MirrorReplayMode}}
+
+	upstream := newTestUpstream(t, "ok", nil)
+	xy.registerRule(ProxyEndpointRule{Host: "example.com", Paths: []ProxyPath{
+		{Path: "/", PathType: PrefixPathType, Upstream: &upstream, EnableReplay: true},
+	}})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			method := []string{http.MethodPost, http.MethodPut}[i%2]
+			request := httptest.NewRequest(method, fmt.Sprintf("http://example.com/items/%d?page=%d", i, i), strings.NewReader(fmt.Sprintf("body-%d", i)))
+			request.Header.Set("X-Request", strconv.Itoa(i))
+
+			send(t, xy, request)
+		}(i)
+	}
+
+	wg.Wait()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf(`expected %d replayed requests`, requests)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i := 0; i < requests; i++ {
+		method := []string{http.MethodPost, http.MethodPut}[i%2]
+		key := fmt.Sprintf("%s /items/%d?page=%d %d", method, i, i, i)
+
+		if body, ok := mirrored[key]; !ok || body != fmt.Sprintf("body-%d", i) {
+			t.Errorf(`expected %q to be replayed with body-%d but got %q`, key, i, body)
+		}
+	}
+}
@@ -1,9 +1,10 @@
 package proxy
 
 import (
+	"context"
 	"errors"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/cleopatrio/proxy/logger"
 	"github.com/gofiber/fiber/v2"
@@ -11,30 +12,147 @@ import (
 )
 
 func (xy *Server) MakeHTTPRequest(c *fiber.Ctx, path ProxyPath) (*http.Response, error) {
+	policy := path.RetryPolicy()
+	timeouts := xy.Timeouts(path)
+	xy.RetryBudget.Deposit()
+
+	// The total timeout spans every attempt and the response body.
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if timeouts.Total > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeouts.Total)
+	}
+
+	finish := func(response *http.Response, err error) (*http.Response, error) {
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		response.Body = &deadlineBody{ReadCloser: response.Body, cancel: cancel}
+		return response, nil
+	}
+
+	var tried []*Backend
+
+	for attempt := 1; ; attempt++ {
+		response, backend, err := xy.sendHTTPRequest(ctx, c, path, timeouts, tried)
+		if backend != nil {
+			tried = append(tried, backend)
+		}
+
+		if attempt >= policy.Attempts || !policy.Retryable(c.Method(), response, err) {
+			return finish(response, err)
+		}
+
+		fields := logrus.Fields{
+			"request.id": c.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader),
+			"method":     c.Method(),
+			"path":       c.Path(),
+			"attempt":    attempt,
+			"error":      err,
+		}
+
+		if response != nil {
+			fields["status"] = response.StatusCode
+		}
+
+		if !xy.RetryBudget.Withdraw() {
+			logger.Logger.WithFields(fields).Warn("Retry budget exhausted 💸")
+			return finish(response, err)
+		}
+
+		discard(response)
+
+		logger.Logger.WithFields(fields).Info("Retrying HTTP request 🔁")
+
+		// The backoff counts towards the total timeout.
+		select {
+		case <-time.After(policy.Delay(attempt)):
+		case <-ctx.Done():
+			return finish(nil, asTimeoutError(ctx.Err()))
+		}
+	}
+}
+
+// sendHTTPRequest - Makes a single attempt, avoiding backends that were already tried when possible.
+func (xy *Server) sendHTTPRequest(ctx context.Context, c *fiber.Ctx, path ProxyPath, timeouts TimeoutSettings, tried []*Backend) (*http.Response, *Backend, error) {
+	var backend *Backend
+	if path.pool != nil {
+		var err error
+		if backend, err = path.pool.Pick(c, tried...); err != nil {
+			return nil, nil, err
+		}
+
+		path.Upstream = &backend.Upstream
+	}
+
 	downstreamURL := path.RequestURL(c.Hostname(), c.Path())
 
 	if downstreamURL == nil {
-		return nil, errors.New(`invalid/unknown downstream url`)
+		if backend != nil {
+			backend.Breaker.Cancel()
+		}
+
+		return nil, nil, errors.New(`invalid/unknown downstream url`)
 	}
 
+	downstreamURL.RawQuery = path.ForwardedQuery(string(c.Request().URI().QueryString()))
+
 	logger.Logger.
 		WithFields(logrus.Fields{"method": c.Method(), "url": downstreamURL.RequestURI(), "tls": path.TLS}).
 		Info("Sending HTTP request 📡")
 
-	headers := map[string][]string{}
-	for k, v := range c.GetReqHeaders() {
-		headers[k] = strings.Split(v, ",")
-	}
-
 	request := http.Request{
 		Method: c.Method(),
-		Header: headers,
+		Header: ForwardedRequestHeaders(c),
 		URL:    downstreamURL,
 	}
 
-	if len(c.Body()) > 0 {
-		request.Body = &RequestBody{Data: c.Body()}
+	xy.Forwarding.Apply(c, request.Header)
+
+	if len(path.RequestHeaders) > 0 {
+		ApplyHeaderRules(path.RequestHeaders, request.Header, NewRequestTemplateData(c, path, xy.Forwarding))
+	}
+
+	// Every attempt gets its own copy of the buffered body (the transport may still
+	// be reading it once the handler returns and the context is recycled). The raw
+	// bytes are forwarded: `c.Body()` would decode compressed bodies.
+	NewRequestBody(append([]byte{}, c.Request().Body()...)).Attach(&request)
+
+	client := path.client
+	if backend != nil && backend.Client != nil {
+		client = backend.Client
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	if backend != nil {
+		backend.acquire()
+	}
+
+	response, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		cancel()
+		err = asTimeoutError(err)
+
+		if backend != nil {
+			backend.release()
+			backend.Breaker.Record(false)
+		}
+
+		return nil, backend, err
+	}
+
+	response.Body = newIdleTimeoutBody(response.Body, timeouts.Idle, cancel)
+
+	if backend != nil {
+		backend.Breaker.Record(response.StatusCode < http.StatusInternalServerError)
+		response.Body = backend.track(response.Body)
 	}
 
-	return http.DefaultClient.Do(&request)
+	return response, backend, nil
 }
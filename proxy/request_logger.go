@@ -4,32 +4,30 @@ import (
 	"time"
 
 	"github.com/cleopatrio/proxy/logger"
-	"github.com/sirupsen/logrus"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func RequestLoggerMiddleware(c *fiber.Ctx) error {
-	reqStart := time.Now()
-
-	// Capture any error returned by the handler
-	err := c.Next()
-
-	duration := time.Duration(time.Now().Sub(reqStart))
-
-	logger.Logger.
-		WithFields(logrus.Fields{
-			"request.id": c.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader),
-			"port":       c.Port(),
-			"ip":         c.IP(),
-			"method":     c.Method(),
-			"status":     c.Response().StatusCode(),
-			"path":       c.Path(),
-			"url":        c.BaseURL(),
-			"duration":   duration.Nanoseconds(),
-		}).
-		WithContext(c.UserContext()).
-		Info("HTTP request finished ✅")
-
-	return err
+// RequestLoggerMiddleware - Logs every request once handled, from a snapshot of the request as received.
+func RequestLoggerMiddleware(forwarding *Forwarding) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		snapshot := newRequestSummary(c, forwarding)
+
+		// Capture any error returned by the handler
+		err := c.Next()
+
+		snapshot.Status = c.Response().StatusCode()
+
+		fields := snapshot.LogFields()
+		fields["port"] = c.Port()
+		fields["url"] = c.BaseURL()
+		fields["duration"] = time.Since(snapshot.Timestamp).Nanoseconds()
+
+		logger.Logger.
+			WithFields(fields).
+			WithContext(c.UserContext()).
+			Info("HTTP request finished ✅")
+
+		return err
+	}
 }
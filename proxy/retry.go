@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cleopatrio/proxy/helpers"
+)
+
+const (
+	// Retryable errors
+	ConnectFailureRetryableError RetryableError = "connect-failure"
+	ResetRetryableError          RetryableError = "reset"
+	TimeoutRetryableError        RetryableError = "timeout"
+
+	// Retry defaults
+	DefaultRetryAttempts          int           = 3
+	DefaultRetryBackoff           time.Duration = 25 * time.Millisecond
+	DefaultRetryMaxBackoff        time.Duration = time.Second
+	DefaultRetryBudgetRatio       float64       = 0.2
+	DefaultRetryBudgetMinPerSec   int           = 3
+	DefaultRetryBudgetWindow      time.Duration = 10 * time.Second
+	maxDrainedRetryResponseLength int64         = 64 << 10
+)
+
+var (
+	// Methods that can safely be sent more than once (RFC 9110, section 9.2.2).
+	IdempotentMethods = []string{
+		http.MethodGet,
+		http.MethodHead,
+		http.MethodOptions,
+		http.MethodTrace,
+		http.MethodPut,
+		http.MethodDelete,
+	}
+
+	DefaultRetryableStatuses = []int{
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+
+	DefaultRetryableErrors = []RetryableError{
+		ConnectFailureRetryableError,
+		ResetRetryableError,
+	}
+)
+
+// RetryableError - Class of transport errors that can be retried.
+type RetryableError string
+
+// RetryPolicy - Controls how failed upstream requests are retried.
+type RetryPolicy struct {
+	// Total number of attempts, including the first one.
+	Attempts int `yaml:"attempts" example:"3"`
+
+	// Upstream responses with these statuses are retried.
+	RetryableStatuses []int `yaml:"retryableStatuses" example:"[502, 503, 504]"`
+
+	// Transport errors of these classes are retried [connect-failure/reset/timeout].
+	RetryableErrors []RetryableError `yaml:"retryableErrors" example:"[connect-failure]"`
+
+	// Base delay of the exponential backoff (full jitter is applied).
+	Backoff time.Duration `yaml:"backoff" example:"25ms"`
+
+	// Upper bound of the delay between two attempts.
+	MaxBackoff time.Duration `yaml:"maxBackoff" example:"1s"`
+
+	// Retry POST, PATCH and other non-idempotent requests as well.
+	RetryNonIdempotent bool `yaml:"retryNonIdempotent"`
+}
+
+func (rp RetryPolicy) withDefaults() RetryPolicy {
+	if rp.Attempts <= 0 {
+		rp.Attempts = DefaultRetryAttempts
+	}
+
+	if rp.RetryableStatuses == nil {
+		rp.RetryableStatuses = DefaultRetryableStatuses
+	}
+
+	if rp.RetryableErrors == nil {
+		rp.RetryableErrors = DefaultRetryableErrors
+	}
+
+	if rp.Backoff <= 0 {
+		rp.Backoff = DefaultRetryBackoff
+	}
+
+	if rp.MaxBackoff <= 0 {
+		rp.MaxBackoff = DefaultRetryMaxBackoff
+	}
+
+	if rp.MaxBackoff < rp.Backoff {
+		rp.MaxBackoff = rp.Backoff
+	}
+
+	return rp
+}
+
+// Retryable - Checks if the outcome of an attempt should be retried.
+func (rp RetryPolicy) Retryable(method string, response *http.Response, err error) bool {
+	if !rp.RetryNonIdempotent && !helpers.Contains(IdempotentMethods, method) {
+		return false
+	}
+
+	if err != nil {
+		class, ok := retryableErrorClass(err)
+		return ok && helpers.Contains(rp.RetryableErrors, class)
+	}
+
+	return response != nil && helpers.Contains(rp.RetryableStatuses, response.StatusCode)
+}
+
+// Delay - Exponential backoff with full jitter before the given retry (starting at 1).
+func (rp RetryPolicy) Delay(retry int) time.Duration {
+	ceiling := rp.Backoff
+	for i := 1; i < retry && ceiling < rp.MaxBackoff; i++ {
+		ceiling *= 2
+	}
+
+	if ceiling > rp.MaxBackoff {
+		ceiling = rp.MaxBackoff
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+func retryableErrorClass(err error) (RetryableError, bool) {
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrNoAvailableBackend) {
+		return "", false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return TimeoutRetryableError, true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return ConnectFailureRetryableError, true
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ConnectFailureRetryableError, true
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ResetRetryableError, true
+	}
+
+	return "", false
+}
+
+// discard - Releases a response that is about to be retried.
+func discard(response *http.Response) {
+	if response == nil || response.Body == nil {
+		return
+	}
+
+	io.CopyN(io.Discard, response.Body, maxDrainedRetryResponseLength)
+	response.Body.Close()
+}
+
+// RetryBudgetSettings - Caps retries server-wide to prevent retry storms.
+//
+// Within every window, retries may not exceed `ratio` of the requests received,
+// though `minRetriesPerSecond` are always allowed.
+type RetryBudgetSettings struct {
+	Ratio               float64       `yaml:"ratio" example:"0.2"`
+	MinRetriesPerSecond int           `yaml:"minRetriesPerSecond" example:"3"`
+	Window              time.Duration `yaml:"window" example:"10s"`
+}
+
+func (rs RetryBudgetSettings) withDefaults() RetryBudgetSettings {
+	if rs.Ratio <= 0 {
+		rs.Ratio = DefaultRetryBudgetRatio
+	}
+
+	if rs.MinRetriesPerSecond <= 0 {
+		rs.MinRetriesPerSecond = DefaultRetryBudgetMinPerSec
+	}
+
+	if rs.Window <= 0 {
+		rs.Window = DefaultRetryBudgetWindow
+	}
+
+	return rs
+}
+
+// RetryBudget - Server-wide retry accounting. A nil budget allows every retry.
+type RetryBudget struct {
+	Settings RetryBudgetSettings
+
+	mu          sync.Mutex
+	now         func() time.Time
+	windowStart time.Time
+	requests    int
+	retries     int
+}
+
+// NewRetryBudget - Creates a retry budget.
+func NewRetryBudget(settings RetryBudgetSettings) *RetryBudget {
+	return &RetryBudget{Settings: settings.withDefaults(), now: time.Now}
+}
+
+// Deposit - Accounts for a new request.
+func (rb *RetryBudget) Deposit() {
+	if rb == nil {
+		return
+	}
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.roll()
+	rb.requests++
+}
+
+// Withdraw - Reserves a retry, returning false once the budget is exhausted.
+func (rb *RetryBudget) Withdraw() bool {
+	if rb == nil {
+		return true
+	}
+
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.roll()
+
+	allowed := int(rb.Settings.Ratio * float64(rb.requests))
+	if minimum := int(rb.Settings.Window.Seconds() * float64(rb.Settings.MinRetriesPerSecond)); allowed < minimum {
+		allowed = minimum
+	}
+
+	if rb.retries >= allowed {
+		return false
+	}
+
+	rb.retries++
+	return true
+}
+
+func (rb *RetryBudget) roll() {
+	if now := rb.now(); now.Sub(rb.windowStart) >= rb.Settings.Window {
+		rb.windowStart = now
+		rb.requests = 0
+		rb.retries = 0
+	}
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlakyUpstream - Answers with `failures` 503s before succeeding.
+func newFlakyUpstream(t *testing.T, failures int32, calls *int32) ProxyUpstream {
+	return newTestUpstream(t, "flaky", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(calls, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		io.WriteString(w, "flaky")
+	})
+}
+
+func Test_Retry(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		policy         *RetryPolicy
+		failures       int32
+		expectedStatus int
+		expectedCalls  int32
+	}{
+		{
+			name:           "no retry policy",
+			method:         http.MethodGet,
+			failures:       1,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCalls:  1,
+		},
+		{
+			name:           "recovers within attempts",
+			method:         http.MethodGet,
+			policy:         &RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+			failures:       2,
+			expectedStatus: http.StatusOK,
+			expectedCalls:  3,
+		},
+		{
+			name:           "attempts exhausted",
+			method:         http.MethodGet,
+			policy:         &RetryPolicy{Attempts: 2, Backoff: time.Millisecond},
+			failures:       5,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCalls:  2,
+		},
+		{
+			name:           "status is not retryable",
+			method:         http.MethodGet,
+			policy:         &RetryPolicy{Attempts: 3, RetryableStatuses: []int{http.StatusBadGateway}, Backoff: time.Millisecond},
+			failures:       2,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCalls:  1,
+		},
+		{
+			name:           "non-idempotent method",
+			method:         http.MethodPost,
+			policy:         &RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+			failures:       2,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCalls:  1,
+		},
+		{
+			name:           "non-idempotent method with override",
+			method:         http.MethodPost,
+			policy:         &RetryPolicy{Attempts: 3, Backoff: time.Millisecond, RetryNonIdempotent: true},
+			failures:       2,
+			expectedStatus: http.StatusOK,
+			expectedCalls:  3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+
+			xy := newTestServer(t, ProxyPath{
+				Upstreams: []ProxyUpstream{newFlakyUpstream(t, tt.failures, &calls)},
+				Retry:     tt.policy,
+			})
+
+			if status, _ := send(t, xy, newRequest(tt.method, "/")); status != tt.expectedStatus {
+				t.Errorf(`expected %d but got %d`, tt.expectedStatus, status)
+			}
+
+			if calls != tt.expectedCalls {
+				t.Errorf(`expected %d calls but got %d`, tt.expectedCalls, calls)
+			}
+		})
+	}
+}
+
+func Test_Retry_DifferentBackend(t *testing.T) {
+	xy := newTestServer(t, ProxyPath{
+		Upstreams: []ProxyUpstream{
+			{Host: "127.0.0.1", Port: 1},
+			newTestUpstream(t, "healthy", nil),
+		},
+		Retry: &RetryPolicy{Attempts: 2, Backoff: time.Millisecond},
+	})
+
+	for i := 0; i < 4; i++ {
+		if status, body := send(t, xy, newRequest(http.MethodGet, "/")); status != http.StatusOK || body != "healthy" {
+			t.Errorf(`expected healthy but got %d %s`, status, body)
+		}
+	}
+}
+
+func Test_RetryBudget(t *testing.T) {
+	clock := &fakeClock{current: time.Unix(0, 0)}
+
+	budget := NewRetryBudget(RetryBudgetSettings{Ratio: 0.5, MinRetriesPerSecond: 1, Window: time.Second})
+	budget.now = clock.now
+
+	for i := 0; i < 4; i++ {
+		budget.Deposit()
+	}
+
+	// max(1 retry per second, 50% of 4 requests)
+	for i := 0; i < 2; i++ {
+		if !budget.Withdraw() {
+			t.Fatalf(`expected retry %d to be allowed`, i+1)
+		}
+	}
+
+	if budget.Withdraw() {
+		t.Fatalf(`expected the retry budget to be exhausted`)
+	}
+
+	clock.advance(time.Second)
+
+	if !budget.Withdraw() || budget.Withdraw() {
+		t.Fatalf(`expected a single retry in the new window`)
+	}
+}
+
+func Test_RetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 40 * time.Millisecond}
+
+	for retry, ceiling := range map[int]time.Duration{1: 10, 2: 20, 3: 40, 8: 40} {
+		for i := 0; i < 20; i++ {
+			if delay := policy.Delay(retry); delay < 0 || delay > ceiling*time.Millisecond {
+				t.Errorf(`expected delay of retry %d to be within [0, %dms] but got %s`, retry, ceiling, delay)
+			}
+		}
+	}
+}
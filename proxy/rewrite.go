@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/cleopatrio/proxy/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// PathRewriteRules - Changes the path of forwarded requests.
+//
+// Rules are applied in this order: `stripPrefix`, `regex`, `addPrefix`.
+type PathRewriteRules struct {
+	// Removed from the beginning of the request path.
+	StripPrefix string `yaml:"stripPrefix" example:"/api"`
+
+	// Request paths matching this expression are replaced with `replacement`.
+	Regex string `yaml:"regex" example:"^/v1/(.*)"`
+
+	// May reference capture groups of `regex`, e.g. `/$1`.
+	Replacement string `yaml:"replacement" example:"/$1"`
+
+	// Added to the beginning of the request path.
+	AddPrefix string `yaml:"addPrefix" example:"/internal"`
+
+	once     sync.Once
+	compiled *regexp.Regexp
+}
+
+// Apply - Rewrites the request path.
+func (pr *PathRewriteRules) Apply(requestPath string) string {
+	if pr.StripPrefix != "" && strings.HasPrefix(requestPath, pr.StripPrefix) {
+		requestPath = strings.TrimPrefix(requestPath, pr.StripPrefix)
+	}
+
+	if regex := pr.regex(); regex != nil {
+		requestPath = regex.ReplaceAllString(requestPath, pr.Replacement)
+	}
+
+	if pr.AddPrefix != "" {
+		requestPath = strings.TrimSuffix(pr.AddPrefix, "/") + "/" + strings.TrimPrefix(requestPath, "/")
+	}
+
+	if !strings.HasPrefix(requestPath, "/") {
+		requestPath = "/" + requestPath
+	}
+
+	return requestPath
+}
+
+// regex - Compiles the rewrite expression once (nil if absent or invalid).
+func (pr *PathRewriteRules) regex() *regexp.Regexp {
+	pr.once.Do(func() {
+		if pr.Regex == "" {
+			return
+		}
+
+		compiled, err := regexp.Compile(pr.Regex)
+		if err != nil {
+			logger.Logger.
+				WithFields(logrus.Fields{"regex": pr.Regex, "error": err}).
+				Error("Invalid path rewrite expression")
+
+			return
+		}
+
+		pr.compiled = compiled
+	})
+
+	return pr.compiled
+}
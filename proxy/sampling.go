@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ReplaySampling - Replays a share of the requests.
+//
+// Requests are sampled by hashing a key, so requests sharing it (e.g. the calls of a session)
+// are either all replayed or not at all, and a request sampled at a rate is also sampled at
+// every higher rate.
+type ReplaySampling struct {
+	// Share of requests replayed, from 0 to 1 (every request if absent).
+	SampleRate *float64 `yaml:"sampleRate" example:"0.1"`
+
+	// Header used as the sampling key (defaults to the request id header).
+	// 	- Requests without the header are sampled at random.
+	SampleKey string `yaml:"sampleKey" example:"X-Session-Id"`
+}
+
+// Sampled - Checks if the request of `c` is replayed.
+func (rs *ReplaySampling) Sampled(c *fiber.Ctx) bool {
+	if rs == nil || rs.SampleRate == nil {
+		return true
+	}
+
+	rate := *rs.SampleRate
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	}
+
+	key := c.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader)
+	if rs.SampleKey != "" {
+		key = c.Get(rs.SampleKey)
+	}
+
+	if key == "" {
+		return rand.Float64() < rate
+	}
+
+	return sampleScore(key) < rate
+}
+
+// sampleScore - Uniformly distributed value in [0, 1) derived from `key`.
+func sampleScore(key string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	// FNV barely spreads the last bytes of similar keys (e.g. sequential ids) to the
+	// high bits, which are mixed in with the MurmurHash3 finalizer.
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	return float64(x>>11) / math.Exp2(53)
+}
+
+// sampledReplayTargets - Replay targets sampling the request of `c` (none if the path does not).
+//
+// Sampling happens before the request is captured, so unsampled requests are not copied.
+func (xy *Server) sampledReplayTargets(c *fiber.Ctx, path ProxyPath) []ReplayTarget {
+	if !path.ReplaySampling.Sampled(c) {
+		return nil
+	}
+
+	targets := []ReplayTarget{}
+
+	for _, target := range xy.Proxyfile.ReplayConfig().ReplayTargets() {
+		if target.ReplaySampling.Sampled(c) {
+			targets = append(targets, target)
+		}
+	}
+
+	return targets
+}
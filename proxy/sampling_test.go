@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sampled - Sampling decision for a request carrying the `X-Session-Id` header (if not empty).
+func sampled(t *testing.T, sampling *ReplaySampling, session string) bool {
+	t.Helper()
+
+	var decision bool
+
+	app := fiber.New()
+	app.All("/*", func(c *fiber.Ctx) error {
+		decision = sampling.Sampled(c)
+		return nil
+	})
+
+	request := newRequest(http.MethodGet, "/")
+	if session != "" {
+		request.Header.Set("X-Session-Id", session)
+	}
+
+	if _, err := app.Test(request, -1); err != nil {
+		t.Fatalf(`unexpected error %v`, err)
+	}
+
+	return decision
+}
+
+func rate(value float64) *float64 { return &value }
+
+func Test_ReplaySampling_Rates(t *testing.T) {
+	tests := []struct {
+		name     string
+		sampling *ReplaySampling
+		sampled  bool
+	}{
+		{name: "absent", sampling: nil, sampled: true},
+		{name: "no rate", sampling: &ReplaySampling{SampleKey: "X-Session-Id"}, sampled: true},
+		{name: "every request", sampling: &ReplaySampling{SampleRate: rate(1)}, sampled: true},
+		{name: "no request", sampling: &ReplaySampling{SampleRate: rate(0)}, sampled: false},
+		{name: "out of range", sampling: &ReplaySampling{SampleRate: rate(-1)}, sampled: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				if decision := sampled(t, tt.sampling, fmt.Sprintf("session-%d", i)); decision != tt.sampled {
+					t.Fatalf(`expected sampling to be %v but got %v`, tt.sampled, decision)
+				}
+			}
+		})
+	}
+}
+
+func Test_ReplaySampling_Deterministic(t *testing.T) {
+	tenth := &ReplaySampling{SampleRate: rate(0.1), SampleKey: "X-Session-Id"}
+	half := &ReplaySampling{SampleRate: rate(0.5), SampleKey: "X-Session-Id"}
+
+	count := 0
+	for i := 0; i < 200; i++ {
+		session := fmt.Sprintf("session-%d", i)
+
+		decision := sampled(t, tenth, session)
+		if again := sampled(t, tenth, session); again != decision {
+			t.Fatalf(`expected requests of %s to be sampled together`, session)
+		}
+
+		if decision && !sampled(t, half, session) {
+			t.Fatalf(`expected %s to be sampled at a higher rate`, session)
+		}
+
+		if decision {
+			count++
+		}
+	}
+
+	if count == 0 || count == 200 {
+		t.Errorf(`expected a share of the sessions to be sampled but got %d`, count)
+	}
+}
+
+func Test_sampleScore(t *testing.T) {
+	const keys = 10000
+
+	below := 0
+	for i := 0; i < keys; i++ {
+		score := sampleScore(fmt.Sprintf("request-%d", i))
+		if score < 0 || score >= 1 {
+			t.Fatalf(`expected a score in [0, 1) but got %v`, score)
+		}
+
+		if score < 0.25 {
+			below++
+		}
+	}
+
+	if share := float64(below) / keys; math.Abs(share-0.25) > 0.02 {
+		t.Errorf(`expected about 25%% of the keys to be sampled but got %v`, share)
+	}
+}
+
+func Test_ReplaySampling_Server(t *testing.T) {
+	settings, received := newReplayTarget(t)
+	settings.Mode = MirrorReplayMode
+
+	xy := &Server{}
+	xy.Proxyfile.Annotations.ReplayRequestsEnabled = true
+	xy.Proxyfile.Spec.Server.Replay = settings
+
+	upstream := newTestUpstream(t, "ok", nil)
+	xy.registerRule(ProxyEndpointRule{Host: "example.com", Paths: []ProxyPath{
+		{Path: "/sampled", PathType: PrefixPathType, Upstream: &upstream, EnableReplay: true},
+		{Path: "/unsampled", PathType: PrefixPathType, Upstream: &upstream, EnableReplay: true, ReplaySampling: &ReplaySampling{SampleRate: rate(0)}},
+	}})
+	t.Cleanup(func() { xy.ReplayDispatcher.Close() })
+
+	send(t, xy, newRequest(http.MethodGet, "/unsampled"))
+	send(t, xy, newRequest(http.MethodGet, "/sampled"))
+
+	xy.ReplayDispatcher.Close()
+
+	if enqueued := xy.ReplayDispatcher.Report().Enqueued; enqueued != 1 {
+		t.Errorf(`expected only the sampled request to be replayed but got %d`, enqueued)
+	}
+
+	if request := <-received; request.RequestURI != "/sampled" {
+		t.Errorf(`expected the sampled request to be replayed but got %s`, request.RequestURI)
+	}
+}
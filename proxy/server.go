@@ -1,10 +1,19 @@
 package proxy
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 
+	"github.com/cleopatrio/proxy/helpers"
 	"github.com/cleopatrio/proxy/logger"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/monitor"
@@ -21,6 +30,36 @@ type Server struct {
 	App       *fiber.App
 	Hosts     map[string]*Host
 	Proxyfile Proxyfile
+
+	// Upstream backends by route (host + path).
+	Pools map[string]*UpstreamPool
+
+	// Server-wide limit on upstream retries.
+	RetryBudget *RetryBudget
+
+	// Dedicated connection pool of the replay target.
+	ReplayClient *http.Client
+
+	// Replays requests in the background with bounded resources.
+	ReplayDispatcher *ReplayDispatcher
+
+	// Replays that failed on every attempt.
+	DeadLetters *DeadLetterFile
+
+	// Comparators of replayed and primary responses by replay target.
+	Comparators map[string]*ShadowComparator
+
+	// Resolves the original client behind trusted proxies.
+	Forwarding *Forwarding
+
+	// Buckets shared by the rate limiters of every path.
+	RateLimitStore RateLimitStore
+
+	// Response caches by route (host + path).
+	Caches map[string]*Cache
+
+	// Request coalescers by route (host + path).
+	Coalescers map[string]*Coalescer
 }
 
 func (xy *Server) registerRule(rule ProxyEndpointRule) {
@@ -30,9 +69,106 @@ func (xy *Server) registerRule(rule ProxyEndpointRule) {
 		xy.Hosts = map[string]*Host{}
 	}
 
+	if xy.Pools == nil {
+		xy.Pools = map[string]*UpstreamPool{}
+	}
+
+	if xy.Caches == nil {
+		xy.Caches = map[string]*Cache{}
+	}
+
+	if xy.Coalescers == nil {
+		xy.Coalescers = map[string]*Coalescer{}
+	}
+
+	if xy.RetryBudget == nil {
+		xy.RetryBudget = NewRetryBudget(xy.Proxyfile.Spec.Server.RetryBudget)
+	}
+
+	if xy.Forwarding == nil {
+		xy.Forwarding = NewForwarding(xy.Proxyfile.Spec.Server.ForwardedHeaders)
+	}
+
+	if xy.RateLimitStore == nil {
+		xy.RateLimitStore = NewRateLimitStore(xy.Proxyfile.Spec.Server.RateLimitStore)
+	}
+
+	if xy.ReplayClient == nil {
+		xy.ReplayClient = newReplayClient(xy.Proxyfile.ServerConfig())
+	}
+
+	if xy.DeadLetters == nil {
+		xy.DeadLetters = NewDeadLetterFile(xy.Proxyfile.ReplayConfig().DeadLetterFile)
+	}
+
+	if xy.Comparators == nil {
+		xy.Comparators = map[string]*ShadowComparator{}
+
+		for _, target := range xy.Proxyfile.ReplayConfig().ReplayTargets() {
+			if target.Compare != nil {
+				xy.Comparators[target.Name] = NewShadowComparator(target.Name, *target.Compare)
+			}
+		}
+	}
+
+	if xy.ReplayDispatcher == nil && xy.Proxyfile.ReplayEnabled() {
+		xy.ReplayDispatcher = NewReplayDispatcher(xy.Proxyfile.ReplayConfig().Dispatcher, func(ctx context.Context, snapshot RequestSnapshot, target ReplayTarget) {
+			xy.deliverReplay(ctx, snapshot, target)
+		})
+	}
+
 	xy.Hosts[rule.Host] = &Host{app}
 
 	for _, path := range rule.Paths {
+		path := path
+		if path.Upstream == nil {
+			path.Upstream = rule.Upstream
+		}
+
+		path.RequestHeaders = append(append([]HeaderRule{}, rule.RequestHeaders...), path.RequestHeaders...)
+		path.ResponseHeaders = append(append([]HeaderRule{}, rule.ResponseHeaders...), path.ResponseHeaders...)
+
+		path.client = xy.newClient(path, nil)
+
+		if path.pool = NewUpstreamPool(path); path.pool != nil {
+			path.pool.Forwarding = xy.Forwarding
+
+			for _, backend := range path.pool.Backends {
+				backend.Client = xy.newClient(path, &backend.Upstream)
+			}
+
+			xy.Pools[rule.Host+path.Path] = path.pool
+		}
+
+		if path.EnableRateLimit && xy.Proxyfile.RateLimitEnabled() {
+			settings := RateLimitSettings{}
+			if path.RateLimit != nil {
+				settings = *path.RateLimit
+			}
+
+			path.limiter = NewRateLimiter(settings, rule.Host+path.Path, xy.RateLimitStore, xy.Forwarding)
+			path.limiter.FailurePolicy = xy.Proxyfile.Spec.Server.RateLimitStore.withDefaults().FailurePolicy
+		}
+
+		if path.Cache != nil {
+			cache, err := NewCache(*path.Cache, rule.Host+path.Path)
+			if err != nil {
+				logger.Logger.WithFields(logrus.Fields{
+					"host":  rule.Host,
+					"path":  path.Path,
+					"error": err,
+				}).Error("Unable to open the cache store, caching is disabled 🗄")
+			} else {
+				path.cache = cache
+				xy.Caches[rule.Host+path.Path] = path.cache
+			}
+		}
+
+		if path.Coalescing != nil {
+			path.coalescer = NewCoalescer(*path.Coalescing)
+			xy.Coalescers[rule.Host+path.Path] = path.coalescer
+		}
+
 		/*
 			Host: example.com
 			Exact  -> /echo  	 -> http://example.com/echo
@@ -48,27 +184,204 @@ func (xy *Server) registerRule(rule ProxyEndpointRule) {
 		}()
 
 		app.All(routerPath, func(c *fiber.Ctx) error {
-			defer func() { go xy.ReplayRequest(*c, xy.Proxyfile, path) }()
+			if path.limiter != nil {
+				if limited, err := path.limiter.Handle(c); limited {
+					return err
+				}
+			}
+
+			// The context is recycled once the handler returns, replay works on a copy
+			// (dispatched once the response status, which targets may filter on, is known).
+			var compared *ResponseSnapshot
+			var comparedLimit int64
+
+			if path.EnableReplay && xy.Proxyfile.ReplayEnabled() {
+				if targets := xy.sampledReplayTargets(c, path); len(targets) > 0 {
+					comparedLimit = comparedBodyLimit(targets)
+
+					snapshot := NewRequestSnapshot(c, xy.Forwarding)
+					defer func() {
+						snapshot.Status = c.Response().StatusCode()
+						snapshot.Response = compared
+						xy.dispatchReplay(snapshot, targets)
+					}()
+				}
+			}
+
+			response, cacheStatus, err := path.cache.Fetch(c, func(c *fiber.Ctx) (*http.Response, error) {
+				return path.coalescer.Fetch(c, func(c *fiber.Ctx) (*http.Response, error) {
+					return xy.MakeHTTPRequest(c, path)
+				})
+			})
+			if errors.Is(err, ErrNoAvailableBackend) {
+				return c.SendStatus(http.StatusServiceUnavailable)
+			}
+
+			if errors.Is(err, ErrCircuitOpen) {
+				logger.Logger.WithFields(logrus.Fields{
+					"request.id":    c.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader),
+					"host":          rule.Host,
+					"path":          c.Path(),
+					"circuit.state": OpenCircuitState,
+				}).Warn("Upstream circuit is open, failing fast ⛔️")
+
+				if retryAfter := path.pool.RetryAfter(); retryAfter > 0 {
+					c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				}
+
+				return c.SendStatus(http.StatusServiceUnavailable)
+			}
+
+			var timeoutErr *TimeoutError
+			if errors.As(err, &timeoutErr) {
+				logger.Logger.WithFields(logrus.Fields{
+					"request.id":    c.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader),
+					"host":          rule.Host,
+					"path":          c.Path(),
+					"timeout.phase": timeoutErr.Phase,
+					"error":         err,
+				}).Error("Upstream request timed out ⏱")
+
+				return c.SendStatus(http.StatusGatewayTimeout)
+			}
 
-			response, err := xy.MakeHTTPRequest(c, path)
 			if err != nil {
 				return c.SendStatus(http.StatusBadGateway)
 			}
 
+			if comparedLimit > 0 {
+				compared = captureResponse(response, comparedLimit)
+			}
+
+			if len(path.ResponseHeaders) > 0 {
+				ApplyHeaderRules(path.ResponseHeaders, response.Header, NewRequestTemplateData(c, path, xy.Forwarding))
+			}
+
 			c.Status(response.StatusCode)
+			CopyResponseHeaders(c, response.Header)
+
+			if cacheStatus != "" {
+				c.Set(XCacheHeader, string(cacheStatus))
+			}
+
+			if response.ContentLength >= 0 {
+				return c.SendStream(response.Body, int(response.ContentLength))
+			}
+
 			return c.SendStream(response.Body)
 		})
 
 		logger.Logger.WithFields(logrus.Fields{
-			"host":     rule.Host,
-			"pathType": path.PathType,
-			"path":     path.Path,
-			"port":     path.PortNumber,
-			"tls":      path.TLS,
+			"host":      rule.Host,
+			"pathType":  path.PathType,
+			"path":      path.Path,
+			"port":      path.PortNumber,
+			"tls":       path.TLS,
+			"upstream":  upstreamAddress(path.Upstream),
+			"rateLimit": path.limiter != nil,
+			"cache":     path.cache != nil,
+			"coalesce":  path.coalescer != nil,
 		}).Debug("Registered route")
 	}
 }
 
+// Timeouts - Upstream timeouts of a path (server defaults with the path's overrides).
+func (xy *Server) Timeouts(path ProxyPath) TimeoutSettings {
+	return xy.Proxyfile.Spec.Server.Timeouts.Merge(path.Timeouts)
+}
+
+// newClient - Creates a dedicated connection pool for a path's upstream.
+func (xy *Server) newClient(path ProxyPath, upstream *ProxyUpstream) *http.Client {
+	settings := xy.Proxyfile.Spec.Server.Transport
+	if upstream != nil {
+		settings = settings.Merge(upstream.Transport)
+	}
+
+	return &http.Client{
+		Transport: NewTransport(xy.Timeouts(path), settings),
+		// Redirects are relayed to the client instead of being followed.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// startHealthChecks - Starts probing the upstreams of every path with health checks enabled.
+func (xy *Server) startHealthChecks() {
+	for _, pool := range xy.Pools {
+		if pool.HealthChecker != nil {
+			pool.HealthChecker.Start()
+		}
+	}
+}
+
+// upstreamsHandler - Reports the health of every upstream by route.
+func (xy *Server) upstreamsHandler(c *fiber.Ctx) error {
+	report := map[string][]HealthReport{}
+
+	for route, pool := range xy.Pools {
+		report[route] = helpers.Map(pool.Backends, func(_ int, b *Backend) HealthReport { return b.Report() })
+	}
+
+	return c.JSON(report)
+}
+
+// replayHandler - Reports the replay queue counters.
+func (xy *Server) replayHandler(c *fiber.Ctx) error {
+	if xy.ReplayDispatcher == nil {
+		return c.JSON(fiber.Map{})
+	}
+
+	return c.JSON(xy.ReplayDispatcher.Report())
+}
+
+// comparisonsHandler - Reports the shadow comparison counters by replay target.
+func (xy *Server) comparisonsHandler(c *fiber.Ctx) error {
+	report := map[string]ComparisonReport{}
+
+	for target, comparator := range xy.Comparators {
+		report[target] = comparator.Report()
+	}
+
+	return c.JSON(report)
+}
+
+// coalescingHandler - Reports the request coalescing counters by route.
+func (xy *Server) coalescingHandler(c *fiber.Ctx) error {
+	report := map[string]CoalescingReport{}
+
+	for route, coalescer := range xy.Coalescers {
+		report[route] = coalescer.Report()
+	}
+
+	return c.JSON(report)
+}
+
+// cachePurgeHandler - Deletes the stored responses matching a purge from every cache.
+func (xy *Server) cachePurgeHandler(c *fiber.Ctx) error {
+	purge := CachePurge{}
+	if err := json.Unmarshal(c.Body(), &purge); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := purge.Validate(); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	purged := 0
+	for _, cache := range xy.Caches {
+		purged += cache.Purge(purge)
+	}
+
+	logger.Logger.WithFields(logrus.Fields{
+		"url":          purge.URL,
+		"surrogateKey": purge.SurrogateKey,
+		"host":         purge.Host,
+		"prefix":       purge.Prefix,
+		"purged":       purged,
+	}).Info("Purged cached responses 🧹")
+
+	return c.JSON(fiber.Map{"purged": purged})
+}
+
 func (xy *Server) getHostname(hostname string) *Host { return xy.Hosts[normalizedHostname(hostname)] }
 
 // Listen - starts listening for HTTP requests.
@@ -97,23 +410,28 @@ func Listen(proxyfile Proxyfile) {
 		FontURL: "https://fonts.googleapis.com/css2?family=REM:wght@300;400;700&display=swap",
 	}))
 
-	server.Use(RequestLoggerMiddleware)
+	forwarding := NewForwarding(proxyfile.Spec.Server.ForwardedHeaders)
+
+	server.Use(RequestLoggerMiddleware(forwarding))
 
 	// ===================================
 	// NOTE: Proxy routes as per Proxyfile
 	// ===================================
 	proxy := Server{
-		App:       server,
-		Hosts:     map[string]*Host{},
-		Proxyfile: proxyfile,
+		App:        server,
+		Hosts:      map[string]*Host{},
+		Proxyfile:  proxyfile,
+		Forwarding: forwarding,
 	}
 
 	for _, rule := range proxyfile.Rules() {
 		proxy.registerRule(rule)
 	}
 
-	// TODO: Handle rate limiting
-	// TODO: Handle caching
+	proxy.startHealthChecks()
+
+	// The admin API is never served on the proxy listener (where `/admin` belongs to proxied hosts).
+	admin := proxy.listenAdmin(proxyfile.Spec.Server.Admin)
 
 	proxy.App.Use(func(c *fiber.Ctx) error {
 		if host := proxy.getHostname(c.Hostname()); host != nil {
@@ -139,7 +457,33 @@ func Listen(proxyfile Proxyfile) {
 		return c.SendStatus(fiber.StatusNotFound)
 	})
 
-	proxy.App.Listen(fmt.Sprintf(":%d", proxyfile.ServerPort()))
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		logger.Logger.Info("Shutting down the proxy server 🛑")
+		proxy.App.Shutdown()
+
+		if admin != nil {
+			admin.Shutdown()
+		}
+	}()
+
+	if err := proxy.App.Listen(fmt.Sprintf(":%d", proxyfile.ServerPort())); err != nil {
+		logger.Logger.Error(err)
+	}
+
+	// In-flight requests are done, the ones waiting to be replayed are sent before exiting.
+	proxy.ReplayDispatcher.Close()
+}
+
+func upstreamAddress(upstream *ProxyUpstream) string {
+	if upstream == nil {
+		return ""
+	}
+
+	return upstream.Address()
 }
 
 func normalizedHostname(hostname string) string {
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// RequestSnapshot - Copy of an incoming request, captured while the handler runs.
+//
+// Fiber recycles request contexts once the handler returns, so work outliving the
+// handler (e.g. replay) must use a snapshot instead of the context. A snapshot must
+// not be modified once captured.
+type RequestSnapshot struct {
+	// Value of the request id header.
+	ID string `json:"id"`
+
+	Method string `json:"method"`
+
+	// Incoming hostname, path and query string.
+	Host     string `json:"host"`
+	Path     string `json:"path"`
+	RawQuery string `json:"query,omitempty"`
+
+	// Every incoming header (including hop-by-hop ones).
+	Header http.Header `json:"headers"`
+
+	// Payload as received (still encoded, as described by `Content-Encoding`).
+	Body []byte `json:"body,omitempty"`
+
+	// Original client (behind trusted proxies).
+	RemoteIP string `json:"remoteIp"`
+
+	// When the request was captured.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Status sent back to the client (once known).
+	Status int `json:"status,omitempty"`
+
+	// Upstream response, when captured for comparison with replayed responses.
+	Response *ResponseSnapshot `json:"-"`
+}
+
+// NewRequestSnapshot - Captures the request of `c`, copying everything it references.
+func NewRequestSnapshot(c *fiber.Ctx, forwarding *Forwarding) RequestSnapshot {
+	snapshot := newRequestSummary(c, forwarding)
+	snapshot.Header = RequestHeaders(c)
+	snapshot.Body = append([]byte{}, c.Request().Body()...)
+
+	return snapshot
+}
+
+// newRequestSummary - Captures the request of `c` without its headers and payload (e.g. for logging).
+func newRequestSummary(c *fiber.Ctx, forwarding *Forwarding) RequestSnapshot {
+	return RequestSnapshot{
+		// Fiber strings point into buffers reused by later requests.
+		ID:        utils.CopyString(c.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader)),
+		Method:    utils.CopyString(c.Method()),
+		Host:      utils.CopyString(c.Hostname()),
+		Path:      utils.CopyString(c.Path()),
+		RawQuery:  string(c.Request().URI().QueryString()),
+		RemoteIP:  utils.CopyString(forwarding.ClientIP(c)),
+		Timestamp: time.Now(),
+	}
+}
+
+// LogFields - Fields describing the request in logs.
+func (rs RequestSnapshot) LogFields() logrus.Fields {
+	return logrus.Fields{
+		"request.id": rs.ID,
+		"method":     rs.Method,
+		"host":       rs.Host,
+		"path":       rs.Path,
+		"ip":         rs.RemoteIP,
+		"status":     rs.Status,
+	}
+}
+
+// ForwardedHeader - Request headers sent upstream (without hop-by-hop headers).
+func (rs RequestSnapshot) ForwardedHeader() http.Header {
+	headers := http.Header{}
+	for name, values := range rs.Header {
+		headers[name] = append([]string{}, values...)
+	}
+
+	return forwardedHeaders(headers)
+}
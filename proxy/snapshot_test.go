@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+func Test_NewRequestSnapshot(t *testing.T) {
+	app := fiber.New()
+
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.Header.SetMethod(fiber.MethodPost)
+	fctx.Request.SetRequestURI("/orders?id=1")
+	fctx.Request.Header.SetHost("example.com")
+	fctx.Request.Header.Add("X-Custom", "a")
+	fctx.Request.Header.Add("X-Custom", "b")
+	fctx.Request.Header.Set(fiber.HeaderConnection, "close")
+	fctx.Request.SetBody([]byte("payload"))
+
+	c := app.AcquireCtx(fctx)
+	c.Set(PxFile.Annotations.HTTPRequestIdHeader, "request-1")
+
+	snapshot := NewRequestSnapshot(c, nil)
+
+	// Reusing the context must not change the snapshot.
+	app.ReleaseCtx(c)
+	fctx.Request.Header.SetMethod(fiber.MethodGet)
+	fctx.Request.SetRequestURI("/other?id=2")
+	fctx.Request.Header.SetHost("example.org")
+	fctx.Request.Header.Set("X-Custom", "c")
+	fctx.Request.SetBody([]byte("changed"))
+	fctx.Response.Header.Set(PxFile.Annotations.HTTPRequestIdHeader, "request-2")
+
+	if snapshot.ID != "request-1" || snapshot.Method != fiber.MethodPost || snapshot.Host != "example.com" ||
+		snapshot.Path != "/orders" || snapshot.RawQuery != "id=1" || !bytes.Equal(snapshot.Body, []byte("payload")) {
+		t.Errorf(`unexpected snapshot %+v`, snapshot)
+	}
+
+	if values := snapshot.Header.Values("X-Custom"); len(values) != 2 || values[0] != "a" || values[1] != "b" {
+		t.Errorf(`expected every header value but got %v`, values)
+	}
+
+	if snapshot.RemoteIP != "0.0.0.0" || snapshot.Timestamp.IsZero() {
+		t.Errorf(`unexpected snapshot %+v`, snapshot)
+	}
+
+	forwarded := snapshot.ForwardedHeader()
+	if forwarded.Get(fiber.HeaderConnection) != "" || forwarded.Get(fiber.HeaderHost) != "" || forwarded.Get("X-Custom") != "a" {
+		t.Errorf(`unexpected forwarded headers %v`, forwarded)
+	}
+
+	if snapshot.Header.Get(fiber.HeaderConnection) != "close" {
+		t.Errorf(`expected forwarded headers not to change the snapshot`)
+	}
+}
+
+func Test_NewRequestSnapshot_EncodedBody(t *testing.T) {
+	compressed := &bytes.Buffer{}
+	writer := gzip.NewWriter(compressed)
+	writer.Write([]byte("payload"))
+	writer.Close()
+
+	app := fiber.New()
+
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.Header.SetMethod(fiber.MethodPost)
+	fctx.Request.Header.Set(fiber.HeaderContentEncoding, "gzip")
+	fctx.Request.SetBody(compressed.Bytes())
+
+	c := app.AcquireCtx(fctx)
+	defer app.ReleaseCtx(c)
+
+	if snapshot := NewRequestSnapshot(c, nil); !bytes.Equal(snapshot.Body, compressed.Bytes()) {
+		t.Errorf(`expected the encoded body to be captured as is but got %q`, snapshot.Body)
+	}
+}
+
+func Test_RequestSnapshot_LogFields(t *testing.T) {
+	app := fiber.New()
+
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.Header.SetMethod(fiber.MethodPost)
+	fctx.Request.SetRequestURI("/orders?id=1")
+	fctx.Request.Header.SetHost("example.com")
+	fctx.Request.SetBody([]byte("payload"))
+
+	c := app.AcquireCtx(fctx)
+	defer app.ReleaseCtx(c)
+
+	c.Set(PxFile.Annotations.HTTPRequestIdHeader, "request-1")
+
+	summary := newRequestSummary(c, nil)
+	if summary.Header != nil || summary.Body != nil {
+		t.Errorf(`expected the headers and payload not to be copied but got %+v`, summary)
+	}
+
+	summary.Status = fiber.StatusCreated
+
+	fields := summary.LogFields()
+	if fields["request.id"] != "request-1" || fields["method"] != fiber.MethodPost || fields["host"] != "example.com" ||
+		fields["path"] != "/orders" || fields["status"] != fiber.StatusCreated {
+		t.Errorf(`unexpected fields %+v`, fields)
+	}
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ${name} - Template variable.
+var templateVariableRegex = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// expandTemplate - Replaces every `${name}` with its resolved value.
+func expandTemplate(value string, resolve func(name string) string) string {
+	if !strings.Contains(value, "${") {
+		return value
+	}
+
+	return templateVariableRegex.ReplaceAllStringFunc(value, func(variable string) string {
+		return resolve(templateVariableRegex.FindStringSubmatch(variable)[1])
+	})
+}
+
+// RequestTemplateData - Request data available to header templates.
+//
+// Variables:
+//
+//	${client.ip}       - Original client address (resolved through trusted proxies)
+//	${request.id}      - Request id
+//	${request.method}  - Request method
+//	${request.host}    - Requested host
+//	${request.path}    - Requested path
+//	${path.matched}    - Path of the matched rule
+//	${header.<name>}   - Incoming request header
+//	${query.<name>}    - Incoming query parameter
+type RequestTemplateData struct {
+	ClientIP    string
+	RequestID   string
+	Method      string
+	Host        string
+	Path        string
+	MatchedPath string
+	Headers     http.Header
+	Query       url.Values
+}
+
+// NewRequestTemplateData - Captures the template data of an incoming request.
+func NewRequestTemplateData(c *fiber.Ctx, path ProxyPath, forwarding *Forwarding) RequestTemplateData {
+	query, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+
+	return RequestTemplateData{
+		ClientIP:    forwarding.ClientIP(c),
+		RequestID:   c.GetRespHeader(PxFile.Annotations.HTTPRequestIdHeader),
+		Method:      c.Method(),
+		Host:        normalizedHostname(string(c.Request().Host())),
+		Path:        c.Path(),
+		MatchedPath: path.Path,
+		Headers:     RequestHeaders(c),
+		Query:       query,
+	}
+}
+
+// Resolve - Value of a template variable (empty if unknown).
+func (data RequestTemplateData) Resolve(name string) string {
+	switch {
+	case name == "client.ip":
+		return data.ClientIP
+	case name == "request.id":
+		return data.RequestID
+	case name == "request.method":
+		return data.Method
+	case name == "request.host":
+		return data.Host
+	case name == "request.path":
+		return data.Path
+	case name == "path.matched":
+		return data.MatchedPath
+	case strings.HasPrefix(name, "header."):
+		return data.Headers.Get(strings.TrimPrefix(name, "header."))
+	case strings.HasPrefix(name, "query."):
+		return data.Query.Get(strings.TrimPrefix(name, "query."))
+	}
+
+	return ""
+}
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cleopatrio/proxy/logger"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Timeout phases
+	ConnectTimeoutPhase        TimeoutPhase = "connect"
+	TLSHandshakeTimeoutPhase   TimeoutPhase = "tls-handshake"
+	ResponseHeaderTimeoutPhase TimeoutPhase = "response-header"
+	IdleTimeoutPhase           TimeoutPhase = "idle"
+	TotalTimeoutPhase          TimeoutPhase = "total"
+)
+
+// TimeoutPhase - Stage of an upstream request that took too long.
+type TimeoutPhase string
+
+// TimeoutSettings - Upper bounds for each stage of an upstream request.
+//
+// Zero values mean "no timeout" for that stage.
+type TimeoutSettings struct {
+	// Establishing the TCP connection.
+	Connect time.Duration `yaml:"connect" example:"5s"`
+
+	// Completing the TLS handshake.
+	TLSHandshake time.Duration `yaml:"tlsHandshake" example:"5s"`
+
+	// Receiving the response headers once the request was written (none by default).
+	// 	- Long polls only answer once there is something to send.
+	ResponseHeader time.Duration `yaml:"responseHeader" example:"30s"`
+
+	// Waiting for the next chunk of the response body (none by default).
+	// 	- Event streams (SSE) may stay quiet for a while.
+	Idle time.Duration `yaml:"idle" example:"30s"`
+
+	// Whole exchange, from the first attempt until the response body is consumed (none by default).
+	// 	- It also cuts long downloads, streams and long polls.
+	Total time.Duration `yaml:"total" example:"60s"`
+}
+
+// Merge - Overrides the settings with every non-zero value of `overrides`.
+func (ts TimeoutSettings) Merge(overrides *TimeoutSettings) TimeoutSettings {
+	if overrides == nil {
+		return ts
+	}
+
+	if overrides.Connect > 0 {
+		ts.Connect = overrides.Connect
+	}
+
+	if overrides.TLSHandshake > 0 {
+		ts.TLSHandshake = overrides.TLSHandshake
+	}
+
+	if overrides.ResponseHeader > 0 {
+		ts.ResponseHeader = overrides.ResponseHeader
+	}
+
+	if overrides.Idle > 0 {
+		ts.Idle = overrides.Idle
+	}
+
+	if overrides.Total > 0 {
+		ts.Total = overrides.Total
+	}
+
+	return ts
+}
+
+// TimeoutError - An upstream request exceeded one of its timeouts.
+type TimeoutError struct {
+	Phase TimeoutPhase
+	Err   error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("upstream %s timeout: %v", e.Phase, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+func (e *TimeoutError) Timeout() bool { return true }
+
+func (e *TimeoutError) Temporary() bool { return true }
+
+var _ net.Error = (*TimeoutError)(nil)
+
+// asTimeoutError - Identifies the phase of a timed out request (if the error is a timeout).
+func asTimeoutError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return err
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &TimeoutError{Phase: TotalTimeoutPhase, Err: err}
+	}
+
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return err
+	}
+
+	// net/http does not export the errors of its own timeouts.
+	switch message := err.Error(); {
+	case strings.Contains(message, "TLS handshake timeout"):
+		return &TimeoutError{Phase: TLSHandshakeTimeoutPhase, Err: err}
+	case strings.Contains(message, "timeout awaiting response headers"):
+		return &TimeoutError{Phase: ResponseHeaderTimeoutPhase, Err: err}
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return &TimeoutError{Phase: ConnectTimeoutPhase, Err: err}
+	}
+
+	return err
+}
+
+// idleTimeoutBody - Aborts the upstream request if a body read stalls for longer than `timeout`.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout  time.Duration
+	cancel   context.CancelFunc
+	timer    *time.Timer
+	timedOut int32
+	once     sync.Once
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) io.ReadCloser {
+	ib := &idleTimeoutBody{ReadCloser: body, timeout: timeout, cancel: cancel}
+
+	if timeout > 0 {
+		ib.timer = time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&ib.timedOut, 1)
+			cancel()
+		})
+		ib.timer.Stop()
+	}
+
+	return ib
+}
+
+func (ib *idleTimeoutBody) Read(buffer []byte) (int, error) {
+	if ib.timer == nil {
+		return ib.ReadCloser.Read(buffer)
+	}
+
+	ib.timer.Reset(ib.timeout)
+	n, err := ib.ReadCloser.Read(buffer)
+	ib.timer.Stop()
+
+	if err != nil && err != io.EOF && atomic.LoadInt32(&ib.timedOut) == 1 {
+		err = &TimeoutError{Phase: IdleTimeoutPhase, Err: err}
+	}
+
+	return n, err
+}
+
+func (ib *idleTimeoutBody) Close() error {
+	ib.once.Do(func() {
+		if ib.timer != nil {
+			ib.timer.Stop()
+		}
+
+		ib.cancel()
+	})
+
+	return ib.ReadCloser.Close()
+}
+
+// deadlineBody - Releases the request context (and its total timeout) once the body is closed.
+type deadlineBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (db *deadlineBody) Read(buffer []byte) (int, error) {
+	n, err := db.ReadCloser.Read(buffer)
+
+	if err != nil && err != io.EOF {
+		err = asTimeoutError(err)
+
+		var timeoutErr *TimeoutError
+		if errors.As(err, &timeoutErr) {
+			logger.Logger.
+				WithFields(logrus.Fields{"timeout.phase": timeoutErr.Phase, "error": err}).
+				Error("Upstream response body timed out ⏱")
+		}
+	}
+
+	return n, err
+}
+
+func (db *deadlineBody) Close() error {
+	defer db.cancel()
+	return db.ReadCloser.Close()
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_Timeouts(t *testing.T) {
+	slow := newTestUpstream(t, "slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(300 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+
+		io.WriteString(w, "slow")
+	})
+
+	tests := []struct {
+		name           string
+		timeouts       *TimeoutSettings
+		expectedStatus int
+	}{
+		{
+			name:           "response header",
+			timeouts:       &TimeoutSettings{ResponseHeader: 50 * time.Millisecond},
+			expectedStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:           "total",
+			timeouts:       &TimeoutSettings{Total: 50 * time.Millisecond},
+			expectedStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:           "within limits",
+			timeouts:       &TimeoutSettings{ResponseHeader: 5 * time.Second, Total: 5 * time.Second},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			xy := newTestServer(t, ProxyPath{Upstreams: []ProxyUpstream{slow}, Timeouts: tt.timeouts})
+
+			if status, _ := send(t, xy, newRequest(http.MethodGet, "/")); status != tt.expectedStatus {
+				t.Errorf(`expected %d but got %d`, tt.expectedStatus, status)
+			}
+		})
+	}
+}
+
+func Test_Timeouts_Backoff(t *testing.T) {
+	upstream := newTestUpstream(t, "", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	xy := newTestServer(t, ProxyPath{
+		Upstream: &upstream,
+		Retry:    &RetryPolicy{Attempts: 2, Backoff: time.Hour, MaxBackoff: time.Hour},
+		Timeouts: &TimeoutSettings{Total: 50 * time.Millisecond},
+	})
+
+	start := time.Now()
+
+	if status, _ := send(t, xy, newRequest(http.MethodGet, "/")); status != http.StatusGatewayTimeout {
+		t.Errorf(`expected %d but got %d`, http.StatusGatewayTimeout, status)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf(`expected the backoff to stop at the total timeout but took %v`, elapsed)
+	}
+}
+
+func Test_IdleTimeoutBody(t *testing.T) {
+	reader, writer := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Unblock the pending read once the idle timeout cancels the request.
+	go func() {
+		<-ctx.Done()
+		writer.CloseWithError(ctx.Err())
+	}()
+
+	body := newIdleTimeoutBody(reader, 20*time.Millisecond, cancel)
+	defer body.Close()
+
+	_, err := body.Read(make([]byte, 8))
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Phase != IdleTimeoutPhase {
+		t.Errorf(`expected an idle timeout but got %v`, err)
+	}
+}
+
+func Test_TimeoutSettings_Merge(t *testing.T) {
+	defaults := TimeoutSettings{Connect: time.Second, ResponseHeader: time.Second, Total: time.Minute}
+
+	merged := defaults.Merge(&TimeoutSettings{ResponseHeader: 5 * time.Second, Idle: 2 * time.Second})
+
+	expected := TimeoutSettings{
+		Connect:        time.Second,
+		ResponseHeader: 5 * time.Second,
+		Idle:           2 * time.Second,
+		Total:          time.Minute,
+	}
+
+	if merged != expected {
+		t.Errorf(`expected %+v but got %+v`, expected, merged)
+	}
+
+	if defaults.Merge(nil) != defaults {
+		t.Errorf(`expected defaults to be kept without overrides`)
+	}
+}
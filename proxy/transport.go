@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// Transport defaults
+	DefaultKeepAlive time.Duration = 30 * time.Second
+)
+
+// TransportSettings - Connection pooling of an upstream.
+//
+// Zero values fall back to the defaults of `http.DefaultTransport`.
+type TransportSettings struct {
+	// Maximum number of idle (keep-alive) connections.
+	MaxIdleConns int `yaml:"maxIdleConns" example:"100"`
+
+	// Maximum number of idle (keep-alive) connections per host.
+	MaxIdleConnsPerHost int `yaml:"maxIdleConnsPerHost" example:"32"`
+
+	// Maximum number of connections per host, including the ones in use.
+	MaxConnsPerHost int `yaml:"maxConnsPerHost" example:"64"`
+
+	// Idle connections are closed after this long.
+	IdleConnTimeout time.Duration `yaml:"idleConnTimeout" example:"90s"`
+
+	// Interval between TCP keep-alive probes.
+	KeepAlive time.Duration `yaml:"keepAlive" example:"30s"`
+
+	// Negotiate HTTP/2 with TLS upstreams (defaults to true).
+	HTTP2 *bool `yaml:"http2"`
+
+	// Honor HTTP_PROXY, HTTPS_PROXY and NO_PROXY (defaults to true).
+	ProxyFromEnvironment *bool `yaml:"proxyFromEnvironment"`
+}
+
+// Merge - Overrides the settings with every non-zero value of `overrides`.
+func (ts TransportSettings) Merge(overrides *TransportSettings) TransportSettings {
+	if overrides == nil {
+		return ts
+	}
+
+	if overrides.MaxIdleConns > 0 {
+		ts.MaxIdleConns = overrides.MaxIdleConns
+	}
+
+	if overrides.MaxIdleConnsPerHost > 0 {
+		ts.MaxIdleConnsPerHost = overrides.MaxIdleConnsPerHost
+	}
+
+	if overrides.MaxConnsPerHost > 0 {
+		ts.MaxConnsPerHost = overrides.MaxConnsPerHost
+	}
+
+	if overrides.IdleConnTimeout > 0 {
+		ts.IdleConnTimeout = overrides.IdleConnTimeout
+	}
+
+	if overrides.KeepAlive > 0 {
+		ts.KeepAlive = overrides.KeepAlive
+	}
+
+	if overrides.HTTP2 != nil {
+		ts.HTTP2 = overrides.HTTP2
+	}
+
+	if overrides.ProxyFromEnvironment != nil {
+		ts.ProxyFromEnvironment = overrides.ProxyFromEnvironment
+	}
+
+	return ts
+}
+
+// NewTransport - Creates a dedicated connection pool enforcing the connect, TLS and response header timeouts.
+func NewTransport(timeouts TimeoutSettings, settings TransportSettings) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	keepAlive := DefaultKeepAlive
+	if settings.KeepAlive > 0 {
+		keepAlive = settings.KeepAlive
+	}
+
+	transport.DialContext = (&net.Dialer{Timeout: timeouts.Connect, KeepAlive: keepAlive}).DialContext
+	transport.TLSHandshakeTimeout = timeouts.TLSHandshake
+	transport.ResponseHeaderTimeout = timeouts.ResponseHeader
+
+	if settings.MaxIdleConns > 0 {
+		transport.MaxIdleConns = settings.MaxIdleConns
+	}
+
+	if settings.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = settings.MaxIdleConnsPerHost
+	}
+
+	if settings.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = settings.MaxConnsPerHost
+	}
+
+	if settings.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = settings.IdleConnTimeout
+	}
+
+	if settings.HTTP2 != nil && !*settings.HTTP2 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	if settings.ProxyFromEnvironment != nil && !*settings.ProxyFromEnvironment {
+		transport.Proxy = nil
+	}
+
+	return transport
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_NewTransport(t *testing.T) {
+	disabled := false
+
+	transport := NewTransport(
+		TimeoutSettings{TLSHandshake: time.Second, ResponseHeader: 2 * time.Second},
+		TransportSettings{
+			MaxIdleConns:         10,
+			MaxIdleConnsPerHost:  5,
+			MaxConnsPerHost:      20,
+			IdleConnTimeout:      time.Minute,
+			HTTP2:                &disabled,
+			ProxyFromEnvironment: &disabled,
+		},
+	)
+
+	if transport.MaxIdleConns != 10 || transport.MaxIdleConnsPerHost != 5 || transport.MaxConnsPerHost != 20 {
+		t.Errorf(`unexpected connection limits %d/%d/%d`, transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost)
+	}
+
+	if transport.IdleConnTimeout != time.Minute {
+		t.Errorf(`expected idle connection timeout of 1m but got %s`, transport.IdleConnTimeout)
+	}
+
+	if transport.TLSHandshakeTimeout != time.Second || transport.ResponseHeaderTimeout != 2*time.Second {
+		t.Errorf(`expected timeouts to be applied`)
+	}
+
+	if transport.ForceAttemptHTTP2 || transport.TLSNextProto == nil || len(transport.TLSNextProto) > 0 {
+		t.Errorf(`expected HTTP/2 to be disabled`)
+	}
+
+	if transport.Proxy != nil {
+		t.Errorf(`expected proxy from environment to be disabled`)
+	}
+
+	if defaults := NewTransport(TimeoutSettings{}, TransportSettings{}); defaults.Proxy == nil || !defaults.ForceAttemptHTTP2 {
+		t.Errorf(`expected the defaults of http.DefaultTransport`)
+	}
+}
+
+func Test_DedicatedTransports(t *testing.T) {
+	a, b := newTestUpstream(t, "a", nil), newTestUpstream(t, "b", nil)
+	b.Transport = &TransportSettings{MaxConnsPerHost: 1}
+
+	xy := newTestServer(t, ProxyPath{Upstreams: []ProxyUpstream{a, b}})
+
+	backends := xy.Pools["example.com/"].Backends
+	if backends[0].Client == backends[1].Client || backends[0].Client.Transport == backends[1].Client.Transport {
+		t.Fatalf(`expected every upstream to have its own transport`)
+	}
+
+	if backends[1].Client.Transport.(*http.Transport).MaxConnsPerHost != 1 {
+		t.Errorf(`expected upstream transport settings to be applied`)
+	}
+
+	for _, backend := range backends {
+		if backend.Client.Transport == xy.ReplayClient.Transport {
+			t.Errorf(`expected replay traffic to use its own transport`)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if status, _ := send(t, xy, newRequest(http.MethodGet, "/")); status != http.StatusOK {
+			t.Errorf(`expected %d but got %d`, http.StatusOK, status)
+		}
+	}
+}
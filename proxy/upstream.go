@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cleopatrio/proxy/helpers"
+	"github.com/gofiber/fiber/v2"
+)
+
+var ErrNoAvailableBackend = errors.New(`no available upstream backend`)
+
+// ProxyUpstream - Explicit destination for forwarded requests.
+//
+// When a rule or path declares an upstream, requests are sent to it instead of
+// re-dialing the hostname the client asked for.
+type ProxyUpstream struct {
+	// Forwarded requests will be sent using this protocol [http/https]
+	Scheme string `yaml:"scheme" example:"http"`
+
+	// Forwarded requests will be sent to this host.
+	Host string `yaml:"host" example:"people-svc.internal"`
+
+	// Forwarded requests will be sent to this port.
+	Port int `yaml:"port" example:"4000"`
+
+	// Forwarded request paths will be prefixed with this path.
+	BasePath string `yaml:"basePath" example:"/v1"`
+
+	// Relative share of traffic for weighted load-balancing policies (defaults to 1).
+	Weight int `yaml:"weight" example:"3"`
+
+	// Overrides the server's connection pooling settings.
+	Transport *TransportSettings `yaml:"transport"`
+}
+
+// Address - Upstream host including its port (if any).
+func (u *ProxyUpstream) Address() string {
+	if u.Port > 0 {
+		return fmt.Sprintf("%s:%d", u.Host, u.Port)
+	}
+
+	return u.Host
+}
+
+// URL - Builds the forwarded request URL for the given request path.
+//
+// If the upstream does not declare a scheme, `tls` decides between http and https.
+func (u *ProxyUpstream) URL(requestPath string, tls bool) (*url.URL, error) {
+	scheme := u.Scheme
+	if scheme == "" {
+		if tls {
+			scheme = "https"
+		} else {
+			scheme = "http"
+		}
+	}
+
+	return url.Parse(scheme + "://" + u.Address() + strings.TrimSuffix(u.BasePath, "/") + requestPath)
+}
+
+// Backend - Runtime state of a single upstream.
+type Backend struct {
+	Upstream ProxyUpstream
+	Health   BackendHealth
+
+	// Passive outlier detection (nil if disabled).
+	Breaker *CircuitBreaker
+
+	// Dedicated connection pool.
+	Client *http.Client
+
+	// Number of in-flight requests.
+	active int64
+}
+
+// ActiveConnections - Number of requests currently being served by this backend.
+func (b *Backend) ActiveConnections() int64 { return atomic.LoadInt64(&b.active) }
+
+func (b *Backend) acquire() { atomic.AddInt64(&b.active, 1) }
+
+func (b *Backend) release() { atomic.AddInt64(&b.active, -1) }
+
+// track - Releases the backend once the response body is closed.
+func (b *Backend) track(body io.ReadCloser) io.ReadCloser {
+	return &trackedBody{ReadCloser: body, release: b.release}
+}
+
+type trackedBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (tb *trackedBody) Close() error {
+	tb.once.Do(tb.release)
+	return tb.ReadCloser.Close()
+}
+
+// UpstreamPool - Set of backends serving a single path.
+type UpstreamPool struct {
+	Backends []*Backend
+	Balancer Balancer
+	Settings LoadBalancerSettings
+
+	// Active health checks (nil if disabled).
+	HealthChecker *HealthChecker
+
+	// Resolves client IPs for consistent hashing.
+	Forwarding *Forwarding
+}
+
+// NewUpstreamPool - Creates the backend pool for a path.
+//
+// Returns nil when the path neither declares `upstream` nor `upstreams`, in which
+// case requests are forwarded to the incoming hostname.
+func NewUpstreamPool(path ProxyPath) *UpstreamPool {
+	upstreams := path.Upstreams
+	if len(upstreams) == 0 && path.Upstream != nil {
+		upstreams = []ProxyUpstream{*path.Upstream}
+	}
+
+	if len(upstreams) == 0 {
+		return nil
+	}
+
+	pool := &UpstreamPool{
+		Balancer: NewBalancer(path.LoadBalancer.Policy),
+		Settings: path.LoadBalancer,
+	}
+
+	for _, upstream := range upstreams {
+		if upstream.Weight <= 0 {
+			upstream.Weight = 1
+		}
+
+		backend := &Backend{Upstream: upstream}
+		if path.CircuitBreaker != nil {
+			backend.Breaker = NewCircuitBreaker(*path.CircuitBreaker, upstream.Address())
+		}
+
+		pool.Backends = append(pool.Backends, backend)
+	}
+
+	if path.HealthCheck != nil {
+		pool.HealthChecker = NewHealthChecker(*path.HealthCheck, pool.Backends, path.TLS)
+	}
+
+	return pool
+}
+
+// Pick - Selects the backend that should serve the request.
+//
+// Backends that failed their health checks or whose circuit is open are out of rotation.
+// Excluded backends (e.g. already tried) are only picked if no other backend is available.
+func (pool *UpstreamPool) Pick(c *fiber.Ctx, exclude ...*Backend) (*Backend, error) {
+	healthy := helpers.Filter(pool.Backends, func(_ int, b *Backend) bool { return b.Health.Healthy() })
+	if len(healthy) == 0 {
+		return nil, ErrNoAvailableBackend
+	}
+
+	candidates := helpers.Filter(healthy, func(_ int, b *Backend) bool { return b.Breaker.Ready() })
+	if len(candidates) == 0 {
+		return nil, ErrCircuitOpen
+	}
+
+	if remaining := helpers.Filter(candidates, func(_ int, b *Backend) bool { return !helpers.Contains(exclude, b) }); len(remaining) > 0 {
+		candidates = remaining
+	}
+
+	backend := pool.Balancer.Pick(candidates, pool.Settings.RequestKey(c, pool.Forwarding))
+	if backend == nil {
+		return nil, ErrNoAvailableBackend
+	}
+
+	if !backend.Breaker.Allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	return backend, nil
+}
+
+// RetryAfter - Shortest time until an ejected backend accepts requests again.
+func (pool *UpstreamPool) RetryAfter() (retryAfter time.Duration) {
+	for _, backend := range pool.Backends {
+		if wait := backend.Breaker.RetryAfter(); wait > 0 && (retryAfter == 0 || wait < retryAfter) {
+			retryAfter = wait
+		}
+	}
+
+	return
+}